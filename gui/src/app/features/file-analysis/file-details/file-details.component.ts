@@ -7,7 +7,7 @@ import { MatTabsModule } from '@angular/material/tabs';
 import { RouterModule } from '@angular/router';
 import { FileFormatComponent } from '../file-format/file-format.component';
 import { FileMetadataComponent } from '../file-metadata/file-metadata.component';
-import { FileAnalysis, FileResult } from '../results';
+import { FeatureValue, FileAnalysis, FileResult } from '../results';
 
 export interface DialogData {
   result: FileResult;
@@ -33,6 +33,15 @@ export class FileDetailsComponent {
   data = inject<DialogData>(MAT_DIALOG_DATA);
   readonly result: FileResult = this.data.result;
   readonly analysis: FileAnalysis = this.data.analysis;
+  readonly conflictFeatures: FeatureValue[] = this.getConflictFeatures();
+
+  /** Returns the format features of the top set with differing values. */
+  getConflictFeatures(): FeatureValue[] {
+    const features = this.analysis.featureSets[0]?.features ?? {};
+    return ['format:puid', 'format:mimeType', 'format:version']
+      .map((key) => features[key])
+      .filter((feature): feature is FeatureValue => !!feature?.alternatives?.length);
+  }
 
   exportResult(): void {
     const a = document.createElement('a');
@@ -161,8 +161,11 @@ export class FileResultsComponent implements AfterViewInit {
         status: {
           valid: result.summary.valid,
           invalid: result.summary.invalid,
-          warning: result.summary.formatUncertain || result.summary.validityConflict,
-          error: result.summary.error,
+          warning:
+            result.summary.formatUncertain ||
+            result.summary.validityConflict ||
+            result.summary.featureConflicts,
+          error: result.summary.errors.length > 0,
         },
       };
       data.push(row);
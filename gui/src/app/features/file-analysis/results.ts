@@ -23,15 +23,32 @@ export interface Summary {
   invalid: boolean;
   formatUncertain: boolean;
   validityConflict: boolean;
-  error: boolean;
+  featureConflicts: boolean;
+  errors: SummaryError[];
   puid: string | null;
   mimeType: string | null;
   formatVersion: string | null;
+  validators: ValidatorVerdict[];
+}
+
+export interface ValidatorVerdict {
+  toolId: string;
+  profile: string;
+  valid: boolean | null;
+  wellFormed: boolean | null;
+  supportsTopSet: boolean;
+}
+
+export interface SummaryError {
+  toolId: string;
+  code: ToolErrorCode;
+  message: string;
 }
 
 export interface FeatureSet {
   score: number;
   supportingTools: string[];
+  conflicts: number;
   features: { [key: string]: FeatureValue | undefined };
 }
 
@@ -39,6 +56,13 @@ export interface FeatureValue {
   value: string | boolean | number;
   label: string | null;
   supportingTools: string[];
+  alternatives?: AlternativeFeatureValue[];
+}
+
+export interface AlternativeFeatureValue {
+  value: string | boolean | number;
+  label: string | null;
+  supportingTools: string[];
 }
 
 export interface ToolResult {
@@ -48,7 +72,25 @@ export interface ToolResult {
   toolOutput: string;
   outputFormat: 'text' | 'json' | 'csv' | 'xml';
   features: { [key: string]: ToolFeatureValue | undefined };
-  error: string | null;
+  score: number | null;
+  error: ToolError | null;
+}
+
+export type ToolErrorCode =
+  | 'timeout'
+  | 'file_not_found'
+  | 'tool_failed'
+  | 'output_unparsable'
+  | 'no_result'
+  | 'unsupported'
+  | 'unavailable'
+  | 'canceled';
+
+export interface ToolError {
+  code: ToolErrorCode;
+  message: string;
+  exitCode: number | null;
+  stderr: string | null;
 }
 
 export interface ToolFeatureValue {
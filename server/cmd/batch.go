@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"lath/borg/internal"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// BATCH_WORKERS is the number of archive entries that are analyzed
+// concurrently.
+const BATCH_WORKERS = 4
+
+type archiveAnalysis struct {
+	// Summary aggregates the summaries of all archive entries.
+	Summary internal.BatchSummary `json:"summary"`
+	// Files contains the analysis of every regular file in the archive, sorted
+	// by path.
+	Files []archiveEntryAnalysis `json:"files"`
+	// DurationInMs represents the duration of the whole batch analysis in
+	// milliseconds.
+	DurationInMs int64 `json:"durationInMs"`
+}
+
+type archiveEntryAnalysis struct {
+	// Path is the path of the file inside the archive.
+	Path     string       `json:"path"`
+	Analysis fileAnalysis `json:"analysis"`
+}
+
+// analyzeArchive unpacks an uploaded ZIP or TAR archive and analyzes every
+// file it contains.
+func analyzeArchive(c *gin.Context) {
+	start := time.Now()
+	file, err := c.FormFile("file")
+	// no file received
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "no file received",
+		})
+		return
+	}
+	config := internal.GetConfig()
+	selection, err := getToolSelection(c, config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	batchId := uuid.New().String()
+	archivePath := filepath.Join(FILE_STORE_PATH, batchId+"_"+file.Filename)
+	err = c.SaveUploadedFile(file, archivePath)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "unable to save file",
+		})
+		return
+	}
+	defer os.Remove(archivePath)
+	extractionDir := filepath.Join(FILE_STORE_PATH, batchId)
+	defer os.RemoveAll(extractionDir)
+	files, err := internal.ExtractArchive(archivePath, extractionDir, config.Archive)
+	if err != nil {
+		log.Println(err)
+		status := http.StatusBadRequest
+		message := "unable to extract archive"
+		switch {
+		case errors.Is(err, internal.ErrUnsupportedArchive):
+			message = "unsupported archive format"
+		case errors.Is(err, internal.ErrArchiveLimitExceeded):
+			status = http.StatusRequestEntityTooLarge
+			message = err.Error()
+		case errors.Is(err, internal.ErrDuplicateEntry):
+			message = err.Error()
+		}
+		c.AbortWithStatusJSON(status, gin.H{
+			"message": message,
+		})
+		return
+	}
+	entries := analyzeArchiveEntries(batchId, files, internal.AnalysisRequest{
+		Context:     c.Request.Context(),
+		Config:      config,
+		BypassCache: isCacheBypassed(c),
+		Tools:       selection,
+		Explain:     isExplainRequested(c),
+	})
+	summaries := make([]internal.Summary, len(entries))
+	for i, entry := range entries {
+		summaries[i] = entry.Analysis.Summary
+	}
+	analysis := archiveAnalysis{
+		Summary:      internal.GetBatchSummary(summaries),
+		Files:        entries,
+		DurationInMs: time.Since(start).Milliseconds(),
+	}
+	respondArchiveAnalysis(c, analysis, file.Filename, config)
+}
+
+// analyzeArchiveEntries analyzes the extracted files of an archive
+// concurrently. The order of the returned entries matches the order of files.
+// The options of the analyses are taken from template.
+func analyzeArchiveEntries(
+	batchId string,
+	files []internal.ExtractedFile,
+	template internal.AnalysisRequest,
+) []archiveEntryAnalysis {
+	entries := make([]archiveEntryAnalysis, len(files))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range BATCH_WORKERS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				start := time.Now()
+				request := template
+				// the tools expect paths relative to the file store
+				request.Filename = filepath.ToSlash(filepath.Join(batchId, files[i].Path))
+				request.Checksums = files[i].Checksums
+				entries[i] = archiveEntryAnalysis{
+					Path:     files[i].Path,
+					Analysis: analyze(request, start),
+				}
+			}
+		}()
+	}
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return entries
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"lath/borg/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invalidateCache removes all cached tool results.
+func invalidateCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"removed": internal.InvalidateCache(),
+	})
+}
+
+// invalidateCacheForFile removes all cached tool results for the file with the
+// SHA-256 hash given as path parameter.
+func invalidateCacheForFile(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"removed": internal.InvalidateCacheForFile(c.Param("hash")),
+	})
+}
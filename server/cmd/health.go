@@ -0,0 +1,63 @@
+package main
+
+import (
+	"lath/borg/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEALTH_OK       = "ok"
+	HEALTH_DEGRADED = "degraded"
+)
+
+// getHealth reports the state of all enabled tool services. The status is
+// degraded if any tool is unavailable.
+func getHealth(c *gin.Context) {
+	tools := internal.CheckToolHealth(c.Request.Context(), internal.GetConfig())
+	status := HEALTH_OK
+	for _, tool := range tools {
+		if !tool.IsAvailable() {
+			status = HEALTH_DEGRADED
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  status,
+		"version": version,
+		"tools":   tools,
+	})
+}
+
+// getReadiness reports whether the server is ready to analyze files. It fails
+// with 503 while a required tool is unavailable, so that orchestration can hold
+// traffic back.
+func getReadiness(c *gin.Context) {
+	tools := internal.CheckToolHealth(c.Request.Context(), internal.GetConfig())
+	unavailableTools := make([]string, 0)
+	for _, tool := range tools {
+		if tool.Required && !tool.IsAvailable() {
+			unavailableTools = append(unavailableTools, tool.Id)
+		}
+	}
+	status := http.StatusOK
+	if len(unavailableTools) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready":            len(unavailableTools) == 0,
+		"unavailableTools": unavailableTools,
+		"tools":            tools,
+	})
+}
+
+// getTools returns the inventory of all configured tools with the versions
+// reported by the tool services.
+func getTools(c *gin.Context) {
+	config := internal.GetConfig()
+	c.JSON(http.StatusOK, gin.H{
+		"configRevision": config.Revision,
+		"tools":          internal.GetToolInventory(c.Request.Context(), config),
+	})
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"lath/borg/internal"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	JOB_STORE_PATH = "/borg/job-store"
+	// JOB_WORKERS is the number of jobs that are processed concurrently.
+	JOB_WORKERS = 2
+	// JOB_QUEUE_SIZE is the maximum number of queued jobs.
+	JOB_QUEUE_SIZE = 10000
+	// JOB_RETENTION is the duration finished jobs and their results are kept.
+	JOB_RETENTION = 7 * 24 * time.Hour
+	// JOB_CLEANUP_INTERVAL is the interval in which expired jobs are removed.
+	JOB_CLEANUP_INTERVAL = time.Hour
+)
+
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+const (
+	TOOL_RUNNING = "running"
+	TOOL_DONE    = "done"
+)
+
+var (
+	ErrJobQueueFull = errors.New("job queue is full")
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobIsRunning = errors.New("job is running")
+)
+
+// job is an asynchronous analysis of an uploaded file.
+type job struct {
+	Id string `json:"id"`
+	// Filename is the name of the uploaded file.
+	Filename string `json:"filename"`
+	// Status is one of "queued", "running", "done" and "failed".
+	Status string `json:"status"`
+	// Tools contains the progress of every requested tool in the order the
+	// tools were started.
+	Tools []jobTool `json:"tools"`
+	// Message describes why the job failed.
+	Message    *string    `json:"message"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	// StoredFilename is the name of the uploaded file in the file store.
+	StoredFilename string `json:"-"`
+	// Checksums are the checksums of the uploaded file.
+	Checksums internal.Checksums `json:"-"`
+	// ExpectedChecksums are the digests expected by the client.
+	ExpectedChecksums internal.Checksums `json:"-"`
+	// BypassCache forces all tools to be requested.
+	BypassCache bool `json:"-"`
+	// ToolSelection restricts the tools used for the analysis.
+	ToolSelection internal.ToolSelection `json:"-"`
+	// Explain records the trace of the merge in the result.
+	Explain bool `json:"-"`
+	// Config is the configuration at the time the job was submitted. It isn't
+	// stored, jobs restored after a restart use the current configuration.
+	Config *internal.ServerConfig `json:"-"`
+}
+
+type jobTool struct {
+	Id string `json:"id"`
+	// Status is either "running" or "done".
+	Status string `json:"status"`
+	// Error means that the tool aborted with an error.
+	Error bool `json:"error"`
+}
+
+// storedJob is the representation of a job in the job store.
+type storedJob struct {
+	job
+	StoredFilename    string                 `json:"storedFilename"`
+	Checksums         internal.Checksums     `json:"checksums"`
+	ExpectedChecksums internal.Checksums     `json:"expectedChecksums"`
+	BypassCache       bool                   `json:"bypassCache"`
+	ToolSelection     internal.ToolSelection `json:"toolSelection"`
+	Explain           bool                   `json:"explain"`
+}
+
+// jobStore persists jobs and their results as JSON files in a directory, so
+// that they survive a server restart.
+type jobStore struct {
+	dir   string
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue chan string
+}
+
+var jobs *jobStore
+
+// initJobs loads all persisted jobs and starts the job workers. Unfinished
+// jobs are queued again if their file still exists in the file store.
+func initJobs() {
+	store, err := openJobStore(JOB_STORE_PATH)
+	if err != nil {
+		log.Fatal("job store not readable\n" + err.Error())
+	}
+	jobs = store
+	for range JOB_WORKERS {
+		go jobs.work()
+	}
+	go func() {
+		for now := range time.Tick(JOB_CLEANUP_INTERVAL) {
+			jobs.removeExpired(now)
+		}
+	}()
+}
+
+func openJobStore(dir string) (*jobStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &jobStore{
+		dir:   dir,
+		jobs:  make(map[string]*job),
+		queue: make(chan string, JOB_QUEUE_SIZE),
+	}
+	var requeued []*job
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".result.json") ||
+			!strings.HasSuffix(name, ".json") {
+			continue
+		}
+		j, err := s.read(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("skipping unreadable job %s: %v", name, err)
+			continue
+		}
+		s.jobs[j.Id] = j
+		if j.isExpired(now) {
+			s.remove(j.Id)
+			continue
+		}
+		if j.Status == JOB_QUEUED || j.Status == JOB_RUNNING {
+			requeued = append(requeued, j)
+		}
+	}
+	for _, j := range requeued {
+		_, err := os.Stat(filepath.Join(FILE_STORE_PATH, j.StoredFilename))
+		if err != nil {
+			s.fail(j, "file was removed before the job was finished")
+			continue
+		}
+		j.Status = JOB_QUEUED
+		j.Tools = make([]jobTool, 0)
+		j.StartedAt = nil
+		s.persist(j)
+		select {
+		case s.queue <- j.Id:
+		default:
+			s.fail(j, ErrJobQueueFull.Error())
+		}
+	}
+	return s, nil
+}
+
+func (s *jobStore) read(path string) (*job, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stored storedJob
+	err = json.Unmarshal(bytes, &stored)
+	if err != nil {
+		return nil, err
+	}
+	j := stored.job
+	j.StoredFilename = stored.StoredFilename
+	j.Checksums = stored.Checksums
+	j.ExpectedChecksums = stored.ExpectedChecksums
+	j.BypassCache = stored.BypassCache
+	j.ToolSelection = stored.ToolSelection
+	j.Explain = stored.Explain
+	return &j, nil
+}
+
+// persist writes the job to the store. The caller must hold the lock of the
+// store or own the job exclusively.
+func (s *jobStore) persist(j *job) {
+	stored := storedJob{
+		job:               *j,
+		StoredFilename:    j.StoredFilename,
+		Checksums:         j.Checksums,
+		ExpectedChecksums: j.ExpectedChecksums,
+		BypassCache:       j.BypassCache,
+		ToolSelection:     j.ToolSelection,
+		Explain:           j.Explain,
+	}
+	err := s.writeFile(j.Id+".json", stored)
+	if err != nil {
+		log.Printf("unable to persist job %s: %v", j.Id, err)
+	}
+}
+
+// writeFile atomically replaces a file in the store with the JSON encoding of
+// value.
+func (s *jobStore) writeFile(name string, value any) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(s.dir, name+".tmp")
+	err = os.WriteFile(tmpPath, bytes, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(s.dir, name))
+}
+
+func (s *jobStore) fail(j *job, message string) {
+	now := time.Now()
+	j.Status = JOB_FAILED
+	j.Message = &message
+	j.FinishedAt = &now
+	s.persist(j)
+}
+
+// submit queues a new job. The file of the job must already be located in
+// the file store.
+func (s *jobStore) submit(j *job) (job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Id = uuid.New().String()
+	j.Status = JOB_QUEUED
+	j.Tools = make([]jobTool, 0)
+	j.CreatedAt = time.Now()
+	select {
+	case s.queue <- j.Id:
+	default:
+		return job{}, ErrJobQueueFull
+	}
+	s.jobs[j.Id] = j
+	s.persist(j)
+	return *j, nil
+}
+
+// get returns a copy of the job with the given id.
+func (s *jobStore) get(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	jobCopy := *j
+	jobCopy.Tools = append([]jobTool(nil), j.Tools...)
+	return jobCopy, true
+}
+
+// isExpired reports whether the job is finished and its retention period
+// expired.
+func (j *job) isExpired(now time.Time) bool {
+	return j.FinishedAt != nil && now.Sub(*j.FinishedAt) > JOB_RETENTION
+}
+
+// removeExpired removes all finished jobs whose retention period expired.
+func (s *jobStore) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		if j.isExpired(now) {
+			s.remove(id)
+		}
+	}
+}
+
+// delete removes a job that isn't running. The file of a queued job is
+// removed as well, the job is then skipped by the workers.
+func (s *jobStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	switch j.Status {
+	case JOB_RUNNING:
+		return ErrJobIsRunning
+	case JOB_QUEUED:
+		os.Remove(filepath.Join(FILE_STORE_PATH, j.StoredFilename))
+	}
+	s.remove(id)
+	return nil
+}
+
+// remove deletes a job and its result from the store. The caller must hold
+// the lock of the store.
+func (s *jobStore) remove(id string) {
+	delete(s.jobs, id)
+	for _, name := range []string{id + ".json", id + ".result.json"} {
+		err := os.Remove(filepath.Join(s.dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("unable to remove job %s: %v", id, err)
+		}
+	}
+}
+
+// getResult reads the analysis of a finished job from the store.
+func (s *jobStore) getResult(id string) (fileAnalysis, error) {
+	var result fileAnalysis
+	bytes, err := os.ReadFile(filepath.Join(s.dir, id+".result.json"))
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(bytes, &result)
+	return result, err
+}
+
+func (s *jobStore) work() {
+	for id := range s.queue {
+		s.run(id)
+	}
+}
+
+func (s *jobStore) run(id string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	start := time.Now()
+	j.Status = JOB_RUNNING
+	j.StartedAt = &start
+	s.persist(j)
+	s.mu.Unlock()
+	defer os.Remove(filepath.Join(FILE_STORE_PATH, j.StoredFilename))
+	result := analyze(
+		internal.AnalysisRequest{
+			Config:            j.Config,
+			Filename:          j.StoredFilename,
+			Progress:          &jobProgress{store: s, job: j},
+			Checksums:         j.Checksums,
+			ExpectedChecksums: j.ExpectedChecksums,
+			BypassCache:       j.BypassCache,
+			Tools:             j.ToolSelection,
+			Explain:           j.Explain,
+		},
+		start,
+	)
+	err := s.writeFile(id+".result.json", result)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.Println(err)
+		s.fail(j, "unable to store analysis result")
+		return
+	}
+	finishedAt := time.Now()
+	j.Status = JOB_DONE
+	j.FinishedAt = &finishedAt
+	s.persist(j)
+}
+
+// jobProgress records the progress of the tools of a running job.
+type jobProgress struct {
+	store *jobStore
+	job   *job
+}
+
+func (p *jobProgress) ToolStarted(toolId string) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	p.job.Tools = append(p.job.Tools, jobTool{
+		Id:     toolId,
+		Status: TOOL_RUNNING,
+	})
+	p.store.persist(p.job)
+}
+
+// ToolsTriggered does nothing, since triggered tools are recorded as soon as
+// they are started.
+func (p *jobProgress) ToolsTriggered(toolIds []string) {}
+
+func (p *jobProgress) ToolFinished(result internal.ToolResult) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	for i, tool := range p.job.Tools {
+		if tool.Id == result.Id {
+			p.job.Tools[i].Status = TOOL_DONE
+			p.job.Tools[i].Error = result.Error != nil
+		}
+	}
+	p.store.persist(p.job)
+}
+
+// submitJob stores an uploaded file and queues an asynchronous analysis for
+// it.
+func submitJob(c *gin.Context) {
+	file, err := c.FormFile("file")
+	// no file received
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "no file received",
+		})
+		return
+	}
+	config := internal.GetConfig()
+	selection, err := getToolSelection(c, config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	expectedChecksums, err := getExpectedChecksums(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	// generate unique file name for storing
+	filename := uuid.New().String() + "_" + file.Filename
+	fileStorePath := filepath.Join(FILE_STORE_PATH, filename)
+	checksums, err := saveUploadedFile(file, fileStorePath)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "unable to save file",
+		})
+		return
+	}
+	j, err := jobs.submit(&job{
+		Filename:          file.Filename,
+		StoredFilename:    filename,
+		Checksums:         checksums,
+		ExpectedChecksums: expectedChecksums,
+		BypassCache:       isCacheBypassed(c),
+		ToolSelection:     selection,
+		Explain:           isExplainRequested(c),
+		Config:            config,
+	})
+	if err != nil {
+		os.Remove(fileStorePath)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, j)
+}
+
+func getJob(c *gin.Context) {
+	j, ok := jobs.get(c.Param("id"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "job not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+func getJobResult(c *gin.Context) {
+	j, ok := jobs.get(c.Param("id"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "job not found",
+		})
+		return
+	}
+	if j.Status != JOB_DONE {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "job is not done",
+			"status":  j.Status,
+		})
+		return
+	}
+	result, err := jobs.getResult(j.Id)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "unable to read job result",
+		})
+		return
+	}
+	respondAnalysis(c, result, j.Filename)
+}
+
+// deleteJob removes a finished or queued job and its result.
+func deleteJob(c *gin.Context) {
+	err := jobs.delete(c.Param("id"))
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+	case errors.Is(err, ErrJobIsRunning):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": err.Error(),
+		})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
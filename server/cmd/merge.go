@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"lath/borg/internal"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// INLINE_CONFIG_PATH names an inline configuration in validation errors.
+const INLINE_CONFIG_PATH = "inline config"
+
+type mergeRequest struct {
+	// ToolResults are the results to merge, for example the tool results of an
+	// earlier analysis.
+	ToolResults []internal.ToolResult `json:"toolResults" binding:"required"`
+	// Config optionally replaces the server configuration for the merge. It is
+	// either a string with the configuration in YAML or an object with the
+	// same structure.
+	Config json.RawMessage `json:"config"`
+}
+
+type mergeResult struct {
+	Summary     internal.Summary      `json:"summary"`
+	FeatureSets []internal.FeatureSet `json:"featureSets"`
+	// MergeTrace explains the merge of the feature sets. It is only included
+	// if the client requested it with the query parameter explain.
+	MergeTrace *internal.MergeTrace `json:"mergeTrace,omitempty"`
+	// ConfigRevision identifies the configuration used for the merge, either
+	// the server configuration or the inline configuration.
+	ConfigRevision string `json:"configRevision"`
+	DurationInMs   int64  `json:"durationInMs"`
+}
+
+// mergeToolResults merges given tool results into feature sets and summarizes
+// them without requesting any tool. This allows replaying earlier analyses
+// against a changed configuration.
+func mergeToolResults(c *gin.Context) {
+	start := time.Now()
+	var body mergeRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "no tool results received",
+		})
+		return
+	}
+	toolResults := make(map[string]internal.ToolResult)
+	for _, result := range body.ToolResults {
+		if _, ok := toolResults[result.Id]; ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("duplicate tool result %q", result.Id),
+			})
+			return
+		}
+		toolResults[result.Id] = result
+	}
+	config := internal.GetConfig()
+	if len(body.Config) > 0 && string(body.Config) != "null" {
+		config, err = parseInlineConfig(body.Config)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"message": "server config invalid",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	var mergedSets []internal.FeatureSet
+	var mergeTrace *internal.MergeTrace
+	if isExplainRequested(c) {
+		mergedSets, mergeTrace = internal.ExplainMergeFeatureSets(config, toolResults)
+	} else {
+		mergedSets = internal.MergeFeatureSets(config, toolResults)
+	}
+	if len(mergedSets) == 0 {
+		mergedSets = make([]internal.FeatureSet, 0)
+	}
+	c.JSON(http.StatusOK, mergeResult{
+		Summary:        internal.GetSummary(config, mergedSets, body.ToolResults),
+		FeatureSets:    mergedSets,
+		MergeTrace:     mergeTrace,
+		ConfigRevision: config.Revision,
+		DurationInMs:   time.Since(start).Milliseconds(),
+	})
+}
+
+// parseInlineConfig parses a configuration given as YAML string or as JSON
+// object, which is valid YAML as well.
+func parseInlineConfig(data json.RawMessage) (*internal.ServerConfig, error) {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		data = json.RawMessage(text)
+	}
+	config, err := internal.ParseConfigData(data, INLINE_CONFIG_PATH)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
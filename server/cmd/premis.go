@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"lath/borg/internal"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	PREMIS_NAMESPACE = "http://www.loc.gov/premis/v3"
+	XSI_NAMESPACE    = "http://www.w3.org/2001/XMLSchema-instance"
+	PREMIS_MIME_TYPE = "application/xml"
+)
+
+// PREMIS_DIGEST_ALGORITHMS maps the checksum algorithms of Borg to the
+// algorithm names of the PREMIS vocabulary.
+var PREMIS_DIGEST_ALGORITHMS = map[string]string{
+	internal.CHECKSUM_MD5:    "MD5",
+	internal.CHECKSUM_SHA1:   "SHA-1",
+	internal.CHECKSUM_SHA256: "SHA-256",
+	internal.CHECKSUM_SHA512: "SHA-512",
+}
+
+type premisDocument struct {
+	XMLName     xml.Name      `xml:"premis:premis"`
+	XmlnsPremis string        `xml:"xmlns:premis,attr"`
+	XmlnsXsi    string        `xml:"xmlns:xsi,attr"`
+	Version     string        `xml:"version,attr"`
+	Object      premisObject  `xml:"premis:object"`
+	Events      []premisEvent `xml:"premis:event"`
+	Agents      []premisAgent `xml:"premis:agent"`
+}
+
+type premisIdentifier struct {
+	Type  string `xml:"premis:objectIdentifierType"`
+	Value string `xml:"premis:objectIdentifierValue"`
+}
+
+type premisObject struct {
+	XsiType         string                      `xml:"xsi:type,attr"`
+	Identifier      premisIdentifier            `xml:"premis:objectIdentifier"`
+	Characteristics premisObjectCharacteristics `xml:"premis:objectCharacteristics"`
+	OriginalName    string                      `xml:"premis:originalName,omitempty"`
+}
+
+type premisObjectCharacteristics struct {
+	CompositionLevel int            `xml:"premis:compositionLevel"`
+	Fixity           []premisFixity `xml:"premis:fixity"`
+	Size             *int64         `xml:"premis:size"`
+	Format           premisFormat   `xml:"premis:format"`
+}
+
+type premisFixity struct {
+	Algorithm  string `xml:"premis:messageDigestAlgorithm"`
+	Digest     string `xml:"premis:messageDigest"`
+	Originator string `xml:"premis:messageDigestOriginator"`
+}
+
+type premisFormat struct {
+	Designation premisFormatDesignation `xml:"premis:formatDesignation"`
+	Registry    *premisFormatRegistry   `xml:"premis:formatRegistry"`
+	Note        []string                `xml:"premis:formatNote"`
+}
+
+type premisFormatDesignation struct {
+	Name    string  `xml:"premis:formatName"`
+	Version *string `xml:"premis:formatVersion"`
+}
+
+type premisFormatRegistry struct {
+	Name string `xml:"premis:formatRegistryName"`
+	Key  string `xml:"premis:formatRegistryKey"`
+	Role string `xml:"premis:formatRegistryRole"`
+}
+
+type premisEvent struct {
+	Identifier struct {
+		Type  string `xml:"premis:eventIdentifierType"`
+		Value string `xml:"premis:eventIdentifierValue"`
+	} `xml:"premis:eventIdentifier"`
+	Type     string `xml:"premis:eventType"`
+	DateTime string `xml:"premis:eventDateTime"`
+	Detail   struct {
+		Detail string `xml:"premis:eventDetail"`
+	} `xml:"premis:eventDetailInformation"`
+	Outcome struct {
+		Outcome string                `xml:"premis:eventOutcome"`
+		Details []premisOutcomeDetail `xml:"premis:eventOutcomeDetail"`
+	} `xml:"premis:eventOutcomeInformation"`
+	LinkingAgent struct {
+		Type  string `xml:"premis:linkingAgentIdentifierType"`
+		Value string `xml:"premis:linkingAgentIdentifierValue"`
+	} `xml:"premis:linkingAgentIdentifier"`
+	LinkingObject premisLinkingObject `xml:"premis:linkingObjectIdentifier"`
+}
+
+type premisOutcomeDetail struct {
+	Note string `xml:"premis:eventOutcomeDetailNote"`
+}
+
+type premisLinkingObject struct {
+	Type  string `xml:"premis:linkingObjectIdentifierType"`
+	Value string `xml:"premis:linkingObjectIdentifierValue"`
+}
+
+type premisAgent struct {
+	Identifier struct {
+		Type  string `xml:"premis:agentIdentifierType"`
+		Value string `xml:"premis:agentIdentifierValue"`
+	} `xml:"premis:agentIdentifier"`
+	Name    string `xml:"premis:agentName"`
+	Type    string `xml:"premis:agentType"`
+	Version string `xml:"premis:agentVersion,omitempty"`
+}
+
+// getPremisObjectIdentifier identifies the file by its SHA-256 digest, so that
+// every analysis of the same content describes the same object.
+func getPremisObjectIdentifier(analysis fileAnalysis, originalName string) premisIdentifier {
+	digest, ok := analysis.FileFeatures["file:checksum:"+internal.CHECKSUM_SHA256]
+	if !ok {
+		return premisIdentifier{Type: "local", Value: originalName}
+	}
+	return premisIdentifier{
+		Type:  PREMIS_DIGEST_ALGORITHMS[internal.CHECKSUM_SHA256],
+		Value: fmt.Sprint(digest.Value),
+	}
+}
+
+// getPremisEventId derives the identifier of an event from the object, the
+// time of the analysis and the tool, so that repeated exports of an analysis
+// contain the same identifiers.
+func getPremisEventId(linkingObject premisLinkingObject, analyzedAt time.Time, toolId string) string {
+	name := fmt.Sprintf(
+		"borg:%s:%s:%s",
+		linkingObject.Value,
+		analyzedAt.UTC().Format(time.RFC3339Nano),
+		toolId,
+	)
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
+
+// respondAnalysis writes the analysis as JSON or, if requested with the query
+// parameter format=premis or an Accept header preferring XML, as PREMIS XML.
+func respondAnalysis(c *gin.Context, analysis fileAnalysis, originalName string) {
+	if !isPremisRequested(c) {
+		c.JSON(http.StatusOK, analysis)
+		return
+	}
+	bytes, err := xml.MarshalIndent(getPremisDocument(analysis, originalName), "", "  ")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "unable to create PREMIS document",
+		})
+		return
+	}
+	c.Data(http.StatusOK, PREMIS_MIME_TYPE, append([]byte(xml.Header), bytes...))
+}
+
+// isPremisRequested reports whether the client requested PREMIS XML. Without
+// the query parameter format, XML has to be preferred over every other media
+// range of the Accept header. Browsers accept XML with a lower quality than
+// HTML and receive JSON.
+func isPremisRequested(c *gin.Context) bool {
+	format := c.Query("format")
+	if format != "" {
+		return format == "premis"
+	}
+	xmlQuality := 0.0
+	otherQuality := 0.0
+	for _, mediaRange := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, quality := parseMediaRange(mediaRange)
+		switch mediaType {
+		case "":
+			continue
+		case gin.MIMEXML, gin.MIMEXML2:
+			xmlQuality = max(xmlQuality, quality)
+		default:
+			otherQuality = max(otherQuality, quality)
+		}
+	}
+	return xmlQuality > otherQuality
+}
+
+// parseMediaRange returns the media type and the quality of a media range of
+// an Accept header. The quality defaults to 1.
+func parseMediaRange(mediaRange string) (string, float64) {
+	parts := strings.Split(mediaRange, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+	quality := 1.0
+	for _, parameter := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+		if strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil && q >= 0 && q <= 1 {
+			quality = q
+		}
+	}
+	return mediaType, quality
+}
+
+// getPremisDocument describes an analysis with PREMIS 3. Every tool becomes an
+// agent with a format identification, validation or metadata extraction
+// event. The feature set with the highest score determines the object
+// characteristics.
+func getPremisDocument(analysis fileAnalysis, originalName string) premisDocument {
+	object := premisObject{
+		XsiType:         "premis:file",
+		Identifier:      getPremisObjectIdentifier(analysis, originalName),
+		Characteristics: getPremisObjectCharacteristics(analysis),
+		OriginalName:    originalName,
+	}
+	linkingObject := premisLinkingObject{
+		Type:  object.Identifier.Type,
+		Value: object.Identifier.Value,
+	}
+	document := premisDocument{
+		XmlnsPremis: PREMIS_NAMESPACE,
+		XmlnsXsi:    XSI_NAMESPACE,
+		Version:     "3.0",
+		Object:      object,
+		Events:      make([]premisEvent, 0),
+		Agents:      make([]premisAgent, 0),
+	}
+	for _, result := range analysis.ToolResults {
+		document.Events = append(
+			document.Events,
+			getPremisEvent(result, analysis.AnalyzedAt, linkingObject),
+		)
+		var agent premisAgent
+		agent.Identifier.Type = "local"
+		agent.Identifier.Value = result.Id
+		agent.Name = result.Title
+		agent.Type = "software"
+		agent.Version = result.ToolVersion
+		document.Agents = append(document.Agents, agent)
+	}
+	var borg premisAgent
+	borg.Identifier.Type = "local"
+	borg.Identifier.Value = "borg"
+	borg.Name = "Borg"
+	borg.Type = "software"
+	borg.Version = version
+	document.Agents = append(document.Agents, borg)
+	return document
+}
+
+func getPremisObjectCharacteristics(analysis fileAnalysis) premisObjectCharacteristics {
+	characteristics := premisObjectCharacteristics{
+		Format: premisFormat{
+			Designation: premisFormatDesignation{Name: "unknown"},
+		},
+	}
+	for _, algorithm := range internal.CHECKSUM_ALGORITHMS {
+		feature, ok := analysis.FileFeatures["file:checksum:"+algorithm]
+		if !ok {
+			continue
+		}
+		characteristics.Fixity = append(characteristics.Fixity, premisFixity{
+			Algorithm:  PREMIS_DIGEST_ALGORITHMS[algorithm],
+			Digest:     fmt.Sprint(feature.Value),
+			Originator: "Borg",
+		})
+	}
+	sizeFeature, ok := analysis.FileFeatures[internal.FILE_SIZE_FEATURE]
+	if ok {
+		switch size := sizeFeature.Value.(type) {
+		case int64:
+			characteristics.Size = &size
+		case float64:
+			// results read from the job store
+			s := int64(size)
+			characteristics.Size = &s
+		}
+	}
+	summary := analysis.Summary
+	if len(analysis.FeatureSets) > 0 {
+		nameFeature, ok := analysis.FeatureSets[0].Features["format:name"]
+		if ok {
+			characteristics.Format.Designation.Name = fmt.Sprint(nameFeature.Value)
+		} else if summary.MimeType != nil {
+			characteristics.Format.Designation.Name = *summary.MimeType
+		}
+		characteristics.Format.Designation.Version = summary.FormatVersion
+	}
+	if summary.PUID != nil {
+		characteristics.Format.Registry = &premisFormatRegistry{
+			Name: "PRONOM",
+			Key:  *summary.PUID,
+			Role: "specification",
+		}
+	}
+	if summary.MimeType != nil {
+		characteristics.Format.Note = append(
+			characteristics.Format.Note,
+			"MIME type: "+*summary.MimeType,
+		)
+	}
+	if summary.FormatUncertain {
+		characteristics.Format.Note = append(
+			characteristics.Format.Note,
+			"The file format could not be identified with sufficient confidence.",
+		)
+	}
+	return characteristics
+}
+
+func getPremisEvent(
+	result internal.ToolResult,
+	analyzedAt time.Time,
+	linkingObject premisLinkingObject,
+) premisEvent {
+	var event premisEvent
+	event.Identifier.Type = "UUID"
+	event.Identifier.Value = getPremisEventId(linkingObject, analyzedAt, result.Id)
+	event.DateTime = analyzedAt.Format(time.RFC3339)
+	event.LinkingAgent.Type = "local"
+	event.LinkingAgent.Value = result.Id
+	event.LinkingObject = linkingObject
+	valid, hasValid := result.Features["format:valid"]
+	wellFormed, hasWellFormed := result.Features["format:wellFormed"]
+	switch {
+	case hasValid || hasWellFormed:
+		event.Type = "validation"
+	// the trigger cause is recorded with the result, so that persisted results
+	// are classified like at the time of the analysis
+	case result.TriggeredBy == nil:
+		event.Type = "format identification"
+	default:
+		event.Type = "metadata extraction"
+	}
+	event.Detail.Detail = fmt.Sprintf("%s %s", result.Title, result.ToolVersion)
+	if result.Error != nil {
+		event.Outcome.Outcome = "error"
+		event.Outcome.Details = append(
+			event.Outcome.Details,
+			premisOutcomeDetail{Note: result.Error.Message},
+		)
+		return event
+	}
+	switch event.Type {
+	case "validation":
+		event.Outcome.Outcome = "pass"
+		if hasWellFormed {
+			if v, ok := wellFormed.Value.(bool); ok && !v {
+				event.Outcome.Outcome = "fail"
+			}
+			event.Outcome.Details = append(
+				event.Outcome.Details,
+				premisOutcomeDetail{Note: fmt.Sprintf("well-formed: %v", wellFormed.Value)},
+			)
+		}
+		if hasValid {
+			if v, ok := valid.Value.(bool); ok && !v {
+				event.Outcome.Outcome = "fail"
+			}
+			event.Outcome.Details = append(
+				event.Outcome.Details,
+				premisOutcomeDetail{Note: fmt.Sprintf("valid: %v", valid.Value)},
+			)
+		}
+	default:
+		event.Outcome.Outcome = "success"
+		for _, key := range []string{"format:puid", "format:mimeType", "format:version"} {
+			feature, ok := result.Features[key]
+			if ok {
+				event.Outcome.Details = append(
+					event.Outcome.Details,
+					premisOutcomeDetail{Note: fmt.Sprintf("%s: %v", key, feature.Value)},
+				)
+			}
+		}
+	}
+	return event
+}
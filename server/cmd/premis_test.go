@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"lath/borg/internal"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondAnalysisFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		accept     string
+		wantPremis bool
+	}{
+		{
+			name:   "no preference",
+			accept: "",
+		},
+		{
+			name:   "any media type",
+			accept: "*/*",
+		},
+		{
+			name:   "browser",
+			accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		},
+		{
+			name:   "JSON and XML with equal quality",
+			accept: "application/json, application/xml",
+		},
+		{
+			name:       "application/xml",
+			accept:     "application/xml",
+			wantPremis: true,
+		},
+		{
+			name:       "text/xml preferred over JSON",
+			accept:     "application/json;q=0.5, text/xml",
+			wantPremis: true,
+		},
+		{
+			name:       "format=premis",
+			query:      "?format=premis",
+			accept:     "application/json",
+			wantPremis: true,
+		},
+		{
+			name:   "other format",
+			query:  "?format=json",
+			accept: "application/xml",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/jobs/1/result"+test.query, nil)
+			if test.accept != "" {
+				c.Request.Header.Set("Accept", test.accept)
+			}
+			respondAnalysis(c, fileAnalysis{}, "test.pdf")
+			contentType := recorder.Header().Get("Content-Type")
+			isPremis := strings.HasPrefix(contentType, PREMIS_MIME_TYPE)
+			if isPremis != test.wantPremis {
+				t.Errorf("got content type %q, want PREMIS %t", contentType, test.wantPremis)
+			}
+		})
+	}
+}
+
+func TestGetPremisDocumentEventTypes(t *testing.T) {
+	analysis := fileAnalysis{
+		AnalyzedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ToolResults: []internal.ToolResult{
+			{
+				Id:       "siegfried",
+				Features: map[string]internal.ToolFeatureValue{"format:puid": {Value: "fmt/19"}},
+			},
+			{
+				Id:          "jhove",
+				Features:    map[string]internal.ToolFeatureValue{"format:valid": {Value: false}},
+				TriggeredBy: &internal.TriggerCause{Mode: internal.TRIGGER_MODE_TOOL_RESULTS},
+			},
+			{
+				Id:          "tika",
+				Features:    map[string]internal.ToolFeatureValue{"format:mimeType": {Value: "application/pdf"}},
+				TriggeredBy: &internal.TriggerCause{Mode: internal.TRIGGER_MODE_TOOL_RESULTS},
+			},
+		},
+	}
+	// results read from the job store are classified like fresh results
+	data, err := json.Marshal(analysis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storedAnalysis fileAnalysis
+	if err := json.Unmarshal(data, &storedAnalysis); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		eventType string
+		outcome   string
+	}{
+		{"format identification", "success"},
+		{"validation", "fail"},
+		{"metadata extraction", "success"},
+	}
+	for _, a := range []fileAnalysis{analysis, storedAnalysis} {
+		document := getPremisDocument(a, "test.pdf")
+		if len(document.Events) != len(want) {
+			t.Fatalf("got %d events, want %d", len(document.Events), len(want))
+		}
+		for i, event := range document.Events {
+			if event.Type != want[i].eventType || event.Outcome.Outcome != want[i].outcome {
+				t.Errorf(
+					"got event %q with outcome %q for %s, want %q with outcome %q",
+					event.Type, event.Outcome.Outcome, event.LinkingAgent.Value,
+					want[i].eventType, want[i].outcome,
+				)
+			}
+		}
+	}
+}
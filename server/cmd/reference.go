@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"lath/borg/internal"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type pathAnalysisRequest struct {
+	// Path is the absolute path of the file inside of a reference root.
+	Path string `json:"path" binding:"required"`
+	// Checksums are optional digests expected by the client, mapped by the
+	// names of the hash algorithms.
+	Checksums map[string]string `json:"checksums"`
+}
+
+// analyzePath analyzes a file that is already located in one of the
+// configured reference roots. The file is neither copied nor removed.
+func analyzePath(c *gin.Context) {
+	start := time.Now()
+	var body pathAnalysisRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "no path received",
+		})
+		return
+	}
+	config := internal.GetConfig()
+	selection, err := getToolSelection(c, config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	expectedChecksums, err := internal.NormalizeChecksums(body.Checksums)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	filename, err := config.ResolveReference(FILE_STORE_PATH, body.Path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"message": "file not found",
+			})
+		case errors.Is(err, internal.ErrPathNotAllowed),
+			errors.Is(err, internal.ErrNotRegularFile):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": err.Error(),
+			})
+		default:
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "unable to resolve path",
+			})
+		}
+		return
+	}
+	checksums, err := internal.ChecksumFile(filepath.Join(FILE_STORE_PATH, filename))
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "unable to read file",
+		})
+		return
+	}
+	request := internal.AnalysisRequest{
+		Context:           c.Request.Context(),
+		Config:            config,
+		Filename:          filename,
+		Checksums:         checksums,
+		ExpectedChecksums: expectedChecksums,
+		BypassCache:       isCacheBypassed(c),
+		Tools:             selection,
+		Explain:           isExplainRequested(c),
+	}
+	respondAnalysis(c, analyze(request, start), filepath.Base(body.Path))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"lath/borg/internal"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+// CONFIG_RELOAD_DELAY is the time to wait after a change of the configuration
+// file before it is reloaded. Editors often write a file in multiple steps.
+const CONFIG_RELOAD_DELAY = 500 * time.Millisecond
+
+var reloadMutex sync.Mutex
+
+// initConfigReload reloads the server configuration on SIGHUP and whenever the
+// configuration file changes.
+func initConfigReload() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Println("received SIGHUP, reloading server config")
+			reloadConfig()
+		}
+	}()
+	err := watchConfig()
+	if err != nil {
+		log.Printf("unable to watch server config, reload with SIGHUP instead: %v", err)
+	}
+}
+
+// watchConfig watches the directory of the configuration file, because editors
+// and mounted volumes replace the file instead of writing to it.
+func watchConfig() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(filepath.Dir(internal.CONFIG_PATH))
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Base(event.Name) != filepath.Base(internal.CONFIG_PATH) ||
+					!event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(CONFIG_RELOAD_DELAY, func() {
+					log.Println("server config changed, reloading")
+					reloadConfig()
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("error watching server config: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadConfig reads and validates the configuration file and replaces the
+// current configuration with it. The current configuration remains active if
+// the file is invalid. Running analyses keep the configuration they started
+// with.
+func reloadConfig() (*internal.ServerConfig, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	config, err := internal.LoadConfig(internal.CONFIG_PATH)
+	if err == nil {
+		err = config.CheckReferenceRoots(FILE_STORE_PATH)
+	}
+	if err != nil {
+		log.Printf("server config not reloaded\n%v", err)
+		return nil, err
+	}
+	current := internal.GetConfig()
+	if current.Revision == config.Revision {
+		return current, nil
+	}
+	internal.SetConfig(&config)
+	log.Printf("server config reloaded, revision %s", config.Revision)
+	// the endpoints of the tools may have changed
+	go internal.RefreshToolVersions(context.Background(), &config)
+	return &config, nil
+}
+
+// reloadConfigHandler reloads the server configuration and returns the
+// revision of the active configuration.
+func reloadConfigHandler(c *gin.Context) {
+	previousRevision := internal.GetConfig().Revision
+	config, err := reloadConfig()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"message":  "server config invalid",
+			"error":    err.Error(),
+			"revision": previousRevision,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"revision": config.Revision,
+		"changed":  config.Revision != previousRevision,
+	})
+}
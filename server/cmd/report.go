@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"lath/borg/internal"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	CSV_MIME_TYPE  = "text/csv; charset=utf-8"
+	XLSX_MIME_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	// REPORT_SHEET is the name of the worksheet in XLSX reports.
+	REPORT_SHEET = "Analysis"
+	// FORMULA_PREFIXES are the first characters of cells that spreadsheet
+	// applications interpret as formula.
+	FORMULA_PREFIXES = "=+-@\t\r"
+)
+
+// REPORT_COLUMNS are the leading columns of a batch report. They are followed
+// by one error column for every enabled tool and every fallback tool in the
+// order of the server configuration used for the analysis.
+var REPORT_COLUMNS = []string{
+	"path",
+	"puid",
+	"mimeType",
+	"formatVersion",
+	"valid",
+	"invalid",
+	"formatUncertain",
+	"error",
+	"score",
+	"supportingTools",
+}
+
+// respondArchiveAnalysis writes the analysis of an archive as JSON or, if
+// requested with the query parameter format=csv or format=xlsx, as a report
+// with one row per file. The columns are derived from config, the
+// configuration the archive was analyzed with.
+func respondArchiveAnalysis(
+	c *gin.Context,
+	analysis archiveAnalysis,
+	archiveName string,
+	config *internal.ServerConfig,
+) {
+	format := c.Query("format")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusOK, analysis)
+		return
+	}
+	toolIds := getReportToolIds(config)
+	header := getReportHeader(toolIds)
+	rows := make([][]any, len(analysis.Files))
+	for i, entry := range analysis.Files {
+		rows[i] = getReportRow(entry, toolIds)
+	}
+	var data []byte
+	var err error
+	mimeType := CSV_MIME_TYPE
+	if format == "csv" {
+		data, err = getCsvReport(header, rows)
+	} else {
+		mimeType = XLSX_MIME_TYPE
+		data, err = getXlsxReport(header, rows)
+	}
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "unable to create report",
+		})
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(archiveName), filepath.Ext(archiveName))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	c.Data(http.StatusOK, mimeType, data)
+}
+
+// getReportToolIds returns the tools that get an error column in the order of
+// the configuration. Besides the enabled tools these are the fallback tools,
+// whether they ran or not, so that the columns only depend on the
+// configuration.
+func getReportToolIds(config *internal.ServerConfig) []string {
+	var toolIds []string
+	for _, toolConfig := range config.Tools {
+		if toolConfig.Enabled || config.IsFallback(toolConfig.Id) {
+			toolIds = append(toolIds, toolConfig.Id)
+		}
+	}
+	return toolIds
+}
+
+func getReportHeader(toolIds []string) []string {
+	header := slices.Clone(REPORT_COLUMNS)
+	for _, id := range toolIds {
+		header = append(header, "error:"+id)
+	}
+	return header
+}
+
+// getReportRow returns the cells of a file in the order of the report header.
+// Missing values are represented by empty strings. Text cells are escaped, so
+// that file names and tool messages aren't evaluated as formula.
+func getReportRow(entry archiveEntryAnalysis, toolIds []string) []any {
+	summary := entry.Analysis.Summary
+	var score any = ""
+	supportingTools := ""
+	if len(entry.Analysis.FeatureSets) > 0 {
+		set := entry.Analysis.FeatureSets[0]
+		score = set.Score
+		tools := slices.Clone(set.SupportingTools)
+		slices.Sort(tools)
+		supportingTools = strings.Join(tools, " ")
+	}
+	row := []any{
+		entry.Path,
+		getOptionalCell(summary.PUID),
+		getOptionalCell(summary.MimeType),
+		getOptionalCell(summary.FormatVersion),
+		summary.Valid,
+		summary.Invalid,
+		summary.FormatUncertain,
+		len(summary.Errors) > 0,
+		score,
+		supportingTools,
+	}
+	toolErrors := make(map[string]string)
+	for _, result := range entry.Analysis.ToolResults {
+		if result.Error != nil {
+			toolErrors[result.Id] = result.Error.Code + ": " + result.Error.Message
+		}
+	}
+	for _, id := range toolIds {
+		row = append(row, toolErrors[id])
+	}
+	for i, cell := range row {
+		if text, ok := cell.(string); ok {
+			row[i] = escapeFormula(text)
+		}
+	}
+	return row
+}
+
+// escapeFormula prefixes text that starts like a formula with an apostrophe.
+func escapeFormula(text string) string {
+	if text != "" && strings.ContainsRune(FORMULA_PREFIXES, rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func getOptionalCell(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// getCsvReport encodes the report as CSV according to RFC 4180.
+func getCsvReport(header []string, rows [][]any) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.UseCRLF = true
+	err := writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			switch value := cell.(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// getXlsxReport encodes the report as an XLSX workbook with a single sheet.
+// Boolean values and scores are stored with their native cell types.
+func getXlsxReport(header []string, rows [][]any) ([]byte, error) {
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+	err := workbook.SetSheetName(workbook.GetSheetName(0), REPORT_SHEET)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := workbook.NewStreamWriter(REPORT_SHEET)
+	if err != nil {
+		return nil, err
+	}
+	headerCells := make([]any, len(header))
+	for i, column := range header {
+		headerCells[i] = column
+	}
+	err = writer.SetRow("A1", headerCells)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.SetRow(cell, row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := workbook.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
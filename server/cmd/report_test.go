@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"lath/borg/internal"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestGetReportToolIds(t *testing.T) {
+	config := &internal.ServerConfig{
+		Tools: []internal.ToolConfig{
+			{Id: "siegfried", Enabled: true, Fallback: "droid"},
+			{Id: "droid"},
+			{Id: "jhove"},
+			{Id: "tika", Enabled: true},
+		},
+	}
+	want := []string{"siegfried", "droid", "tika"}
+	if got := getReportToolIds(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("got tool ids %v, want %v", got, want)
+	}
+	wantHeader := slices.Concat(REPORT_COLUMNS, []string{"error:siegfried", "error:droid", "error:tika"})
+	if got := getReportHeader(want); !reflect.DeepEqual(got, wantHeader) {
+		t.Errorf("got header %v, want %v", got, wantHeader)
+	}
+}
+
+func TestGetReportRowEscapesFormulas(t *testing.T) {
+	entry := archiveEntryAnalysis{
+		Path: "=HYPERLINK(\"http://example.com\")",
+		Analysis: fileAnalysis{
+			ToolResults: []internal.ToolResult{{
+				Id:    "droid",
+				Error: &internal.ToolError{Code: "tool_failed", Message: "failed"},
+			}},
+		},
+	}
+	row := getReportRow(entry, []string{"siegfried", "droid"})
+	if row[0] != "'=HYPERLINK(\"http://example.com\")" {
+		t.Errorf("got path cell %q, want it escaped", row[0])
+	}
+	if row[len(row)-2] != "" || row[len(row)-1] != "tool_failed: failed" {
+		t.Errorf("got error cells %q, want no error for siegfried and the error of droid", row[len(row)-2:])
+	}
+}
+
+func TestRespondArchiveAnalysisReport(t *testing.T) {
+	config := &internal.ServerConfig{
+		Tools: []internal.ToolConfig{{Id: "siegfried", Enabled: true}},
+	}
+	puid := "fmt/19"
+	analysis := archiveAnalysis{
+		Files: []archiveEntryAnalysis{{
+			Path: "docs/test.pdf",
+			Analysis: fileAnalysis{
+				Summary: internal.Summary{PUID: &puid},
+			},
+		}},
+	}
+	wantHeader := slices.Concat(REPORT_COLUMNS, []string{"error:siegfried"})
+	tests := []struct {
+		format          string
+		wantContentType string
+		readRows        func(data []byte) ([][]string, error)
+	}{
+		{
+			format:          "csv",
+			wantContentType: CSV_MIME_TYPE,
+			readRows: func(data []byte) ([][]string, error) {
+				return csv.NewReader(bytes.NewReader(data)).ReadAll()
+			},
+		},
+		{
+			format:          "xlsx",
+			wantContentType: XLSX_MIME_TYPE,
+			readRows: func(data []byte) ([][]string, error) {
+				workbook, err := excelize.OpenReader(bytes.NewReader(data))
+				if err != nil {
+					return nil, err
+				}
+				defer workbook.Close()
+				return workbook.GetRows(REPORT_SHEET)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/analyze-archive?format="+test.format, nil)
+			respondArchiveAnalysis(c, analysis, "files.zip", config)
+			if got := recorder.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("got content type %q, want %q", got, test.wantContentType)
+			}
+			disposition := recorder.Header().Get("Content-Disposition")
+			if !strings.Contains(disposition, `filename="files.`+test.format+`"`) {
+				t.Errorf("got content disposition %q, want the name of the archive", disposition)
+			}
+			rows, err := test.readRows(recorder.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 2 {
+				t.Fatalf("got %d rows, want header and one file", len(rows))
+			}
+			if !reflect.DeepEqual(rows[0], wantHeader) {
+				t.Errorf("got header %v, want %v", rows[0], wantHeader)
+			}
+			if rows[1][0] != "docs/test.pdf" || rows[1][1] != puid {
+				t.Errorf("got row %v, want path and PUID of the file", rows[1])
+			}
+		})
+	}
+}
@@ -1,26 +1,35 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"lath/borg/internal"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	DEFAULT_RESPONSE = "Borg server version %s is running"
 	FILE_STORE_PATH  = "/borg/file-store"
+	// CHECKSUM_FIELD_PREFIX starts the names of the form fields with expected
+	// digests, like checksum:sha256.
+	CHECKSUM_FIELD_PREFIX = "checksum:"
 )
 
 var version = os.Getenv("BORG_VERSION")
 
+var FILE_SIZE_LABEL = "Dateigröße"
+
 type fileAnalysis struct {
 	// Summary describes the overall verification result.
 	Summary internal.Summary `json:"summary"`
@@ -29,11 +38,34 @@ type fileAnalysis struct {
 	// ToolResults is a list of complete responses from all tools, mapped by
 	// tool name.
 	ToolResults []internal.ToolResult `json:"toolResults"`
+	// Profile is the analysis profile that selected the tools, empty if all
+	// enabled tools were available.
+	Profile string `json:"profile"`
+	// SkippedTools lists the configured tools that were not used and why.
+	SkippedTools []internal.SkippedTool `json:"skippedTools"`
+	// ExecutionOrder contains the ids of the tools run in every round. The
+	// first round is the identification, followed by the rounds of triggered
+	// tools.
+	ExecutionOrder [][]string `json:"executionOrder"`
+	// FileFeatures are features determined by Borg itself, like the checksums
+	// of the file.
+	FileFeatures map[string]internal.ToolFeatureValue `json:"fileFeatures"`
+	// AnalyzedAt is the point in time the analysis was started.
+	AnalyzedAt time.Time `json:"analyzedAt"`
+	// ConfigRevision identifies the server configuration used for the
+	// analysis.
+	ConfigRevision string `json:"configRevision"`
+	// MergeTrace explains the merge of the feature sets. It is only included
+	// if the client requested it with the query parameter explain.
+	MergeTrace *internal.MergeTrace `json:"mergeTrace,omitempty"`
 	// DurationInMs represents the duration of the analysis in milliseconds.
 	DurationInMs int64 `json:"durationInMs"`
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
 	log.Printf(DEFAULT_RESPONSE, version)
 	initServer()
 	router := gin.Default()
@@ -44,17 +76,50 @@ func main() {
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"*"}
 	corsConfig.AllowHeaders = []string{"Origin", "Content-Type"}
-	corsConfig.AllowMethods = []string{"GET", "POST"}
+	corsConfig.AllowMethods = []string{"GET", "POST", "DELETE"}
 	// It's important that the cors configuration is used before declaring the routes.
 	router.Use(cors.New(corsConfig))
 	router.GET("api", getDefaultResponse)
 	router.GET("api/version", getVersion)
+	router.GET("api/health", getHealth)
+	router.GET("api/ready", getReadiness)
+	router.GET("api/tools", getTools)
+	router.GET("metrics", gin.WrapH(promhttp.Handler()))
 	router.POST("api/analyze", analyzeFile)
+	router.POST("api/analyze-stream", analyzeFileStream)
+	router.POST("api/analyze-archive", analyzeArchive)
+	router.POST("api/analyze-path", analyzePath)
+	router.POST("api/merge", mergeToolResults)
+	router.POST("api/jobs", submitJob)
+	router.GET("api/jobs/:id", getJob)
+	router.GET("api/jobs/:id/result", getJobResult)
+	router.DELETE("api/jobs/:id", deleteJob)
+	router.DELETE("api/cache", invalidateCache)
+	router.DELETE("api/cache/:hash", invalidateCacheForFile)
+	router.POST("api/admin/reload-config", reloadConfigHandler)
 	router.Run()
 }
 
 func initServer() {
 	internal.ParseConfig()
+	err := internal.GetConfig().CheckReferenceRoots(FILE_STORE_PATH)
+	if err != nil {
+		log.Fatal(err)
+	}
+	initJobs()
+	initConfigReload()
+	initToolVersionRefresh()
+}
+
+// initToolVersionRefresh requests the versions of the tools for the cache of
+// tool results now and then periodically.
+func initToolVersionRefresh() {
+	go func() {
+		internal.RefreshToolVersions(context.Background(), internal.GetConfig())
+		for range time.Tick(internal.TOOL_VERSION_REFRESH_INTERVAL) {
+			internal.RefreshToolVersions(context.Background(), internal.GetConfig())
+		}
+	}()
 }
 
 func getDefaultResponse(c *gin.Context) {
@@ -75,10 +140,27 @@ func analyzeFile(c *gin.Context) {
 		})
 		return
 	}
+	// the configuration is read once, so that a reload doesn't affect the
+	// running analysis
+	config := internal.GetConfig()
+	selection, err := getToolSelection(c, config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	expectedChecksums, err := getExpectedChecksums(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
 	// generate unique file name for storing
 	filename := uuid.New().String() + "_" + file.Filename
 	fileStorePath := filepath.Join(FILE_STORE_PATH, filename)
-	err = c.SaveUploadedFile(file, fileStorePath)
+	checksums, err := saveUploadedFile(file, fileStorePath)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 			"message": "unable to save file",
@@ -86,19 +168,128 @@ func analyzeFile(c *gin.Context) {
 		return
 	}
 	defer os.Remove(fileStorePath)
-	identResults := internal.RunIdentificationTools(filename)
-	triggeredResults := internal.RunTriggeredTools(filename, identResults)
+	request := internal.AnalysisRequest{
+		Context:           c.Request.Context(),
+		Config:            config,
+		Filename:          filename,
+		Checksums:         checksums,
+		ExpectedChecksums: expectedChecksums,
+		BypassCache:       isCacheBypassed(c),
+		Tools:             selection,
+		Explain:           isExplainRequested(c),
+	}
+	respondAnalysis(c, analyze(request, start), file.Filename)
+}
+
+// saveUploadedFile writes an uploaded file to the file store and returns the
+// checksums of its content.
+func saveUploadedFile(file *multipart.FileHeader, path string) (internal.Checksums, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return internal.SaveFile(src, path)
+}
+
+// getExpectedChecksums reads the digests expected by the client from the form
+// fields named CHECKSUM_FIELD_PREFIX and a hash algorithm, like checksum:md5,
+// checksum:sha1, checksum:sha256 or checksum:sha512. Other form fields are
+// ignored.
+func getExpectedChecksums(c *gin.Context) (internal.Checksums, error) {
+	digests := make(map[string]string)
+	for name := range c.Request.PostForm {
+		algorithm, ok := strings.CutPrefix(name, CHECKSUM_FIELD_PREFIX)
+		if ok {
+			digests[algorithm] = c.PostForm(name)
+		}
+	}
+	return internal.NormalizeChecksums(digests)
+}
+
+// isCacheBypassed reports whether the client requested to bypass the cache for
+// tool results with the query parameter noCache.
+func isCacheBypassed(c *gin.Context) bool {
+	return c.Query("noCache") == "true"
+}
+
+// isExplainRequested reports whether the client requested the trace of the
+// merge with the query parameter explain.
+func isExplainRequested(c *gin.Context) bool {
+	return c.Query("explain") == "true"
+}
+
+// getToolSelection reads the tools requested by the client from the query
+// parameters profile, include and exclude. The tool ids of include and
+// exclude are given as repeated or comma-separated parameters and are checked
+// against config.
+func getToolSelection(c *gin.Context, config *internal.ServerConfig) (internal.ToolSelection, error) {
+	selection := internal.ToolSelection{
+		Profile: c.Query("profile"),
+		Include: getQueryList(c, "include"),
+		Exclude: getQueryList(c, "exclude"),
+	}
+	return selection, config.CheckToolSelection(selection)
+}
+
+func getQueryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, param := range c.QueryArray(key) {
+		for _, value := range strings.Split(param, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// analyze runs all tools for a file in the file store and merges their
+// results. The duration of the analysis is measured from start. The current
+// configuration is used if the request doesn't contain one.
+func analyze(request internal.AnalysisRequest, start time.Time) fileAnalysis {
+	if request.Config == nil {
+		request.Config = internal.GetConfig()
+	}
+	fileFeatures := request.Checksums.Features()
+	info, err := os.Stat(filepath.Join(FILE_STORE_PATH, request.Filename))
+	if err == nil {
+		fileFeatures[internal.FILE_SIZE_FEATURE] = internal.ToolFeatureValue{
+			Value: info.Size(),
+			Label: &FILE_SIZE_LABEL,
+		}
+	}
+	request.FileFeatures = fileFeatures
+	identResults := internal.RunIdentificationTools(request)
+	triggeredResults, triggerRounds := internal.RunTriggeredTools(request, identResults)
 	toolResults := internal.CombineToolResults(identResults, triggeredResults)
-	mergedSets := internal.MergeFeatureSets(toolResults)
+	var mergedSets []internal.FeatureSet
+	var mergeTrace *internal.MergeTrace
+	if request.Explain {
+		mergedSets, mergeTrace = internal.ExplainMergeFeatureSets(request.Config, toolResults)
+	} else {
+		mergedSets = internal.MergeFeatureSets(request.Config, toolResults)
+	}
 	if len(mergedSets) == 0 {
 		mergedSets = make([]internal.FeatureSet, 0)
 	}
 	tr := internal.GetSortedToolResults(identResults, triggeredResults)
-	fileAnalysis := fileAnalysis{
-		Summary:      internal.GetSummary(mergedSets, tr),
-		FeatureSets:  mergedSets,
-		ToolResults:  tr,
-		DurationInMs: time.Since(start).Milliseconds(),
+	summary := internal.GetSummary(request.Config, mergedSets, tr)
+	summary.ChecksumMismatch = request.Checksums.Verify(request.ExpectedChecksums)
+	canceled := request.Context != nil && request.Context.Err() != nil
+	internal.RecordAnalysis(summary, time.Since(start), canceled)
+	return fileAnalysis{
+		Summary:        summary,
+		Profile:        request.Config.GetProfileId(request.Tools),
+		SkippedTools:   internal.GetSkippedTools(request.Config, request.Tools, toolResults),
+		ExecutionOrder: append([][]string{request.Config.GetToolOrder(identResults)}, triggerRounds...),
+		FeatureSets:    mergedSets,
+		ToolResults:    tr,
+		FileFeatures:   fileFeatures,
+		AnalyzedAt:     start,
+		ConfigRevision: request.Config.Revision,
+		MergeTrace:     mergeTrace,
+		DurationInMs:   time.Since(start).Milliseconds(),
 	}
-	c.JSON(http.StatusOK, fileAnalysis)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"lath/borg/internal"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetExpectedChecksums(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    internal.Checksums
+		wantErr error
+	}{
+		{
+			name: "checksum fields",
+			form: url.Values{
+				"checksum:SHA-256": {"abc"},
+				"checksum:md5":     {"def"},
+			},
+			want: internal.Checksums{internal.CHECKSUM_SHA256: "abc", internal.CHECKSUM_MD5: "def"},
+		},
+		{
+			name: "other fields are ignored",
+			form: url.Values{
+				"filename":        {"test.pdf"},
+				"csrf_token":      {"token"},
+				"checksum:sha512": {"abc"},
+			},
+			want: internal.Checksums{internal.CHECKSUM_SHA512: "abc"},
+		},
+		{
+			name:    "unknown algorithm",
+			form:    url.Values{"checksum:crc32": {"abc"}},
+			wantErr: internal.ErrUnknownChecksumAlgorithm,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(
+				http.MethodPost,
+				"/api/analyze",
+				strings.NewReader(test.form.Encode()),
+			)
+			c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			// the handlers parse the form before with FormFile
+			if err := c.Request.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := getExpectedChecksums(c)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got checksums %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"lath/borg/internal"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type streamEvent struct {
+	name string
+	data any
+}
+
+// streamProgress forwards the progress of an analysis as server-sent events.
+// Events are dropped once the client has disconnected.
+type streamProgress struct {
+	events chan<- streamEvent
+	done   <-chan struct{}
+}
+
+func (p *streamProgress) send(name string, data any) {
+	select {
+	case p.events <- streamEvent{name: name, data: data}:
+	case <-p.done:
+	}
+}
+
+func (p *streamProgress) ToolStarted(toolId string) {
+	p.send("toolStarted", gin.H{"id": toolId})
+}
+
+func (p *streamProgress) ToolFinished(result internal.ToolResult) {
+	p.send("toolResult", result)
+}
+
+func (p *streamProgress) ToolsTriggered(toolIds []string) {
+	p.send("toolsTriggered", gin.H{"ids": toolIds})
+}
+
+// analyzeFileStream analyzes an uploaded file like analyzeFile, but streams
+// the progress as server-sent events. The events are
+//   - toolStarted: a tool was requested
+//   - toolResult: the result of a tool is available
+//   - toolsTriggered: the ids of all tools triggered in a round
+//   - result: the complete analysis including the merged feature sets
+func analyzeFileStream(c *gin.Context) {
+	start := time.Now()
+	file, err := c.FormFile("file")
+	// no file received
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "no file received",
+		})
+		return
+	}
+	config := internal.GetConfig()
+	selection, err := getToolSelection(c, config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	expectedChecksums, err := getExpectedChecksums(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	// generate unique file name for storing
+	filename := uuid.New().String() + "_" + file.Filename
+	fileStorePath := filepath.Join(FILE_STORE_PATH, filename)
+	checksums, err := saveUploadedFile(file, fileStorePath)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "unable to save file",
+		})
+		return
+	}
+	events := make(chan streamEvent)
+	progress := &streamProgress{
+		events: events,
+		done:   c.Request.Context().Done(),
+	}
+	request := internal.AnalysisRequest{
+		Context:           c.Request.Context(),
+		Config:            config,
+		Filename:          filename,
+		Progress:          progress,
+		Checksums:         checksums,
+		ExpectedChecksums: expectedChecksums,
+		BypassCache:       isCacheBypassed(c),
+		Tools:             selection,
+		Explain:           isExplainRequested(c),
+	}
+	go func() {
+		defer close(events)
+		defer os.Remove(fileStorePath)
+		progress.send("result", analyze(request, start))
+	}()
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		c.SSEvent(event.name, event.data)
+		return true
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"lath/borg/internal"
+	"os"
+)
+
+// validateConfig implements the subcommand validate-config, which checks a
+// server configuration without starting the server. The optional argument is
+// the path of the configuration file. It returns the exit code.
+func validateConfig(args []string) int {
+	path := internal.CONFIG_PATH
+	if len(args) > 0 {
+		path = args[0]
+	}
+	config, err := internal.LoadConfig(path)
+	if err == nil {
+		err = config.CheckReferenceRoots(FILE_STORE_PATH)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
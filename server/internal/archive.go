@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	zipMagicNumber  = []byte("PK\x03\x04")
+	gzipMagicNumber = []byte{0x1f, 0x8b}
+	tarMagicNumber  = []byte("ustar")
+)
+
+// tarMagicOffset is the position of the magic number in a tar header block.
+const tarMagicOffset = 257
+
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	// ErrArchiveLimitExceeded means that the archive contains too many files
+	// or too much data.
+	ErrArchiveLimitExceeded = errors.New("archive exceeds the extraction limits")
+	// ErrDuplicateEntry means that the archive contains the same path more
+	// than once, which would overwrite the extracted file.
+	ErrDuplicateEntry = errors.New("duplicate archive entry")
+)
+
+// Default limits of the archive extraction.
+const (
+	DEFAULT_MAX_ARCHIVE_ENTRIES    = 10000
+	DEFAULT_MAX_ARCHIVE_ENTRY_SIZE = 4 << 30  // 4 GiB
+	DEFAULT_MAX_ARCHIVE_TOTAL_SIZE = 16 << 30 // 16 GiB
+)
+
+// ArchiveLimits restrict the extraction of archives, so that an archive bomb
+// neither fills the file store nor starts an excessive number of tool runs.
+// Zero values are replaced by the defaults.
+type ArchiveLimits struct {
+	// MaxEntries is the maximum number of regular files in an archive.
+	MaxEntries int `yaml:"maxEntries"`
+	// MaxEntrySize is the maximum uncompressed size of a single file in bytes.
+	MaxEntrySize int64 `yaml:"maxEntrySize"`
+	// MaxTotalSize is the maximum uncompressed size of all files in bytes.
+	MaxTotalSize int64 `yaml:"maxTotalSize"`
+	line         int
+}
+
+// withDefaults returns the limits with defaults for all missing values. The
+// limits may be nil.
+func (l *ArchiveLimits) withDefaults() ArchiveLimits {
+	var limits ArchiveLimits
+	if l != nil {
+		limits = *l
+	}
+	if limits.MaxEntries == 0 {
+		limits.MaxEntries = DEFAULT_MAX_ARCHIVE_ENTRIES
+	}
+	if limits.MaxEntrySize == 0 {
+		limits.MaxEntrySize = DEFAULT_MAX_ARCHIVE_ENTRY_SIZE
+	}
+	if limits.MaxTotalSize == 0 {
+		limits.MaxTotalSize = DEFAULT_MAX_ARCHIVE_TOTAL_SIZE
+	}
+	return limits
+}
+
+// extraction tracks the files extracted from a single archive.
+type extraction struct {
+	targetDir string
+	limits    ArchiveLimits
+	files     []ExtractedFile
+	paths     map[string]bool
+	totalSize int64
+}
+
+// ExtractedFile is a regular file that was extracted from an archive.
+type ExtractedFile struct {
+	// Path is the path of the file relative to the target directory.
+	Path string
+	// Checksums are the checksums of the file content.
+	Checksums Checksums
+}
+
+// ExtractArchive unpacks a ZIP, TAR or gzip compressed TAR archive into the
+// target directory.
+//
+// It returns all extracted regular files sorted by path. Directories, links
+// and other special entries are skipped. Entries that would be written outside
+// the target directory, duplicate entries and archives exceeding the limits
+// are rejected. The limits may be nil.
+func ExtractArchive(
+	archivePath string,
+	targetDir string,
+	limits *ArchiveLimits,
+) ([]ExtractedFile, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	header, err := reader.Peek(tarMagicOffset + len(tarMagicNumber))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	e := extraction{
+		targetDir: targetDir,
+		limits:    limits.withDefaults(),
+		paths:     make(map[string]bool),
+	}
+	switch {
+	case bytes.HasPrefix(header, zipMagicNumber):
+		err = e.extractZip(archivePath)
+	case bytes.HasPrefix(header, gzipMagicNumber):
+		gzipReader, gzipErr := gzip.NewReader(reader)
+		if gzipErr != nil {
+			return nil, gzipErr
+		}
+		defer gzipReader.Close()
+		err = e.extractTar(gzipReader)
+	case isTarHeader(header):
+		err = e.extractTar(reader)
+	default:
+		return nil, ErrUnsupportedArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(e.files, func(a, b ExtractedFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return e.files, nil
+}
+
+func isTarHeader(header []byte) bool {
+	if len(header) < tarMagicOffset+len(tarMagicNumber) {
+		return false
+	}
+	return bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagicNumber)], tarMagicNumber)
+}
+
+func (e *extraction) extractZip(archivePath string) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	for _, entry := range zipReader.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		entryReader, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = e.extractEntry(entryReader, entry.Name)
+		entryReader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extraction) extractTar(r io.Reader) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = e.extractEntry(tarReader, header.Name)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractEntry writes a single archive entry to the target directory. The
+// size of the entry is limited while it's written, since the sizes declared
+// in the archive can't be trusted.
+func (e *extraction) extractEntry(r io.Reader, name string) error {
+	path := filepath.Clean(filepath.FromSlash(name))
+	if !filepath.IsLocal(path) {
+		return fmt.Errorf("archive entry outside of target directory: %s", name)
+	}
+	slashPath := filepath.ToSlash(path)
+	if e.paths[slashPath] {
+		return fmt.Errorf("%w: %s", ErrDuplicateEntry, name)
+	}
+	if len(e.files) >= e.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d files", ErrArchiveLimitExceeded, e.limits.MaxEntries)
+	}
+	targetPath := filepath.Join(e.targetDir, path)
+	err := os.MkdirAll(filepath.Dir(targetPath), 0o755)
+	if err != nil {
+		return err
+	}
+	limit := min(e.limits.MaxEntrySize, e.limits.MaxTotalSize-e.totalSize)
+	// one additional byte reveals whether the entry exceeds the limit
+	checksums, err := SaveFile(io.LimitReader(r, limit+1), targetPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() > limit {
+		os.Remove(targetPath)
+		if limit < e.limits.MaxEntrySize {
+			return fmt.Errorf(
+				"%w: more than %d bytes in total",
+				ErrArchiveLimitExceeded,
+				e.limits.MaxTotalSize,
+			)
+		}
+		return fmt.Errorf(
+			"%w: %s is larger than %d bytes",
+			ErrArchiveLimitExceeded,
+			name,
+			e.limits.MaxEntrySize,
+		)
+	}
+	e.totalSize += info.Size()
+	e.paths[slashPath] = true
+	e.files = append(e.files, ExtractedFile{
+		Path:      slashPath,
+		Checksums: checksums,
+	})
+	return nil
+}
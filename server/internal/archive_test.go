@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	content string
+}
+
+func writeTestZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, entry := range entries {
+		w, err := writer.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(entry.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeTestTar(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.tar")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+	for _, entry := range entries {
+		err = writer.WriteHeader(&tar.Header{
+			Name:     entry.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(entry.content)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write([]byte(entry.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name      string
+		write     func(*testing.T, []testEntry) string
+		entries   []testEntry
+		limits    *ArchiveLimits
+		wantErr   error
+		wantPaths []string
+	}{
+		{
+			name:      "zip",
+			write:     writeTestZip,
+			entries:   []testEntry{{"b.txt", "b"}, {"dir/a.txt", "a"}},
+			wantPaths: []string{"b.txt", "dir/a.txt"},
+		},
+		{
+			name:      "tar",
+			write:     writeTestTar,
+			entries:   []testEntry{{"b.txt", "b"}, {"dir/a.txt", "a"}},
+			wantPaths: []string{"b.txt", "dir/a.txt"},
+		},
+		{
+			name:    "zip path traversal",
+			write:   writeTestZip,
+			entries: []testEntry{{"../evil.txt", "evil"}},
+		},
+		{
+			name:    "tar path traversal",
+			write:   writeTestTar,
+			entries: []testEntry{{"dir/../../evil.txt", "evil"}},
+		},
+		{
+			name:    "tar absolute path",
+			write:   writeTestTar,
+			entries: []testEntry{{"/etc/evil.txt", "evil"}},
+		},
+		{
+			name:    "tar duplicate entry",
+			write:   writeTestTar,
+			entries: []testEntry{{"a.txt", "old"}, {"./a.txt", "new"}},
+			wantErr: ErrDuplicateEntry,
+		},
+		{
+			name:    "too many entries",
+			write:   writeTestZip,
+			entries: []testEntry{{"a.txt", "a"}, {"b.txt", "b"}, {"c.txt", "c"}},
+			limits:  &ArchiveLimits{MaxEntries: 2},
+			wantErr: ErrArchiveLimitExceeded,
+		},
+		{
+			name:    "entry too large",
+			write:   writeTestTar,
+			entries: []testEntry{{"a.txt", "12345"}},
+			limits:  &ArchiveLimits{MaxEntrySize: 4},
+			wantErr: ErrArchiveLimitExceeded,
+		},
+		{
+			name:      "entry at the size limit",
+			write:     writeTestZip,
+			entries:   []testEntry{{"a.txt", "1234"}},
+			limits:    &ArchiveLimits{MaxEntrySize: 4},
+			wantPaths: []string{"a.txt"},
+		},
+		{
+			name:    "total size too large",
+			write:   writeTestZip,
+			entries: []testEntry{{"a.txt", "1234"}, {"b.txt", "1234"}},
+			limits:  &ArchiveLimits{MaxEntrySize: 4, MaxTotalSize: 6},
+			wantErr: ErrArchiveLimitExceeded,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archivePath := test.write(t, test.entries)
+			targetDir := filepath.Join(t.TempDir(), "target")
+			files, err := ExtractArchive(archivePath, targetDir, test.limits)
+			if test.wantPaths == nil {
+				if err == nil {
+					t.Fatalf("got %d files, want an error", len(files))
+				}
+				if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v, want %v", err, test.wantErr)
+				}
+				// nothing may be written outside the target directory
+				_, statErr := os.Stat(filepath.Join(filepath.Dir(targetDir), "evil.txt"))
+				if statErr == nil {
+					t.Fatal("archive entry was written outside of the target directory")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, file := range files {
+				paths = append(paths, file.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(test.wantPaths, ",") {
+				t.Fatalf("got paths %v, want %v", paths, test.wantPaths)
+			}
+		})
+	}
+}
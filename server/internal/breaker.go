@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig configures how tools that fail repeatedly are skipped.
+// Tools are never skipped if the option is missing.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests after
+	// which a tool is skipped.
+	FailureThreshold int `yaml:"failureThreshold"`
+	// CoolDown is the duration a tool is skipped, for example "30s". Then a
+	// single request probes whether the tool is available again.
+	CoolDown time.Duration `yaml:"coolDown"`
+	line     int
+}
+
+const (
+	CIRCUIT_CLOSED    = "closed"
+	CIRCUIT_OPEN      = "open"
+	CIRCUIT_HALF_OPEN = "halfOpen"
+)
+
+// circuit is the state of a single tool. A closed circuit allows all
+// requests, an open circuit skips the tool and a half-open circuit waits for
+// the result of the probe.
+type circuit struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// lastError is the error of the last failed request, which is tracked
+	// even if the circuit breaker is disabled.
+	lastError   *string
+	lastErrorAt *time.Time
+}
+
+// circuitBreakers track the failed requests of all tools. The tool wrapper
+// being unreachable, a server error, an exceeded timeout and a failed tool
+// count as failures. Other errors reported for a file, like an unsupported
+// format, don't.
+type circuitBreakers struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+var breakers = circuitBreakers{
+	circuits: make(map[string]*circuit),
+}
+
+// allow reports whether the tool may be requested.
+func (b *circuitBreakers) allow(config *CircuitBreakerConfig, toolId string) bool {
+	if config == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[toolId]
+	if !ok {
+		return true
+	}
+	switch c.state {
+	case CIRCUIT_OPEN:
+		if time.Since(c.openedAt) < config.CoolDown {
+			return false
+		}
+		log.Printf("cool-down of tool %s expired, probing the tool", toolId)
+		c.state = CIRCUIT_HALF_OPEN
+		return true
+	case CIRCUIT_HALF_OPEN:
+		// only the probe is requested
+		return false
+	}
+	return true
+}
+
+// getCircuit returns the circuit of a tool. The caller must hold the lock.
+func (b *circuitBreakers) getCircuit(toolId string) *circuit {
+	c, ok := b.circuits[toolId]
+	if !ok {
+		c = &circuit{state: CIRCUIT_CLOSED}
+		b.circuits[toolId] = c
+	}
+	return c
+}
+
+// record updates the circuit of a tool with the outcome of a request.
+func (b *circuitBreakers) record(
+	config *CircuitBreakerConfig,
+	toolId string,
+	status toolRequestStatus,
+	toolError *ToolError,
+) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.getCircuit(toolId)
+	if (status == TOOL_REQUEST_FAILED || status == TOOL_REQUEST_TIMEOUT) && toolError != nil {
+		c.setLastError(&toolError.Message)
+	}
+	if config == nil {
+		return
+	}
+	switch status {
+	case TOOL_REQUEST_OK:
+		if c.state != CIRCUIT_CLOSED {
+			log.Printf("tool %s is available again", toolId)
+		}
+		c.state = CIRCUIT_CLOSED
+		c.failures = 0
+	case TOOL_REQUEST_CANCELED:
+		// a canceled probe doesn't tell anything, the next request probes again
+		if c.state == CIRCUIT_HALF_OPEN {
+			c.state = CIRCUIT_OPEN
+		}
+	default:
+		c.failures++
+		if c.state == CIRCUIT_HALF_OPEN || c.failures >= config.FailureThreshold {
+			if c.state != CIRCUIT_OPEN {
+				log.Printf(
+					"tool %s failed %d times in a row, skipping it for %s",
+					toolId,
+					c.failures,
+					config.CoolDown,
+				)
+			}
+			c.state = CIRCUIT_OPEN
+			c.openedAt = time.Now()
+		}
+	}
+}
+
+func (c *circuit) setLastError(errorMessage *string) {
+	now := time.Now()
+	c.lastError = errorMessage
+	c.lastErrorAt = &now
+}
+
+// recordError remembers an error of a tool that doesn't affect the circuit,
+// like a failed health check.
+func (b *circuitBreakers) recordError(toolId string, errorMessage string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.getCircuit(toolId).setLastError(&errorMessage)
+}
+
+// getState returns the state of the circuit of a tool and its last error.
+func (b *circuitBreakers) getState(toolId string) (string, *string, *time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[toolId]
+	if !ok {
+		return CIRCUIT_CLOSED, nil, nil
+	}
+	return c.state, c.lastError, c.lastErrorAt
+}
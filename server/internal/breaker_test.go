@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"context"
+	"lath/borg/toolapi"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// breakerStep either records the outcome of a request or checks whether a
+// request is allowed. expire lets the cool-down expire before the step.
+type breakerStep struct {
+	record    *toolRequestStatus
+	expire    bool
+	wantAllow bool
+	wantState string
+}
+
+func recordStep(status toolRequestStatus, wantState string) breakerStep {
+	return breakerStep{record: &status, wantState: wantState}
+}
+
+func allowStep(wantAllow bool, wantState string) breakerStep {
+	return breakerStep{wantAllow: wantAllow, wantState: wantState}
+}
+
+func expireStep(wantAllow bool, wantState string) breakerStep {
+	return breakerStep{expire: true, wantAllow: wantAllow, wantState: wantState}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	config := &CircuitBreakerConfig{FailureThreshold: 2, CoolDown: time.Hour}
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{
+			name: "closed, open, half-open and closed again",
+			steps: []breakerStep{
+				allowStep(true, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_TIMEOUT, CIRCUIT_OPEN),
+				allowStep(false, CIRCUIT_OPEN),
+				expireStep(true, CIRCUIT_HALF_OPEN),
+				// only the probe is allowed
+				allowStep(false, CIRCUIT_HALF_OPEN),
+				recordStep(TOOL_REQUEST_OK, CIRCUIT_CLOSED),
+				allowStep(true, CIRCUIT_CLOSED),
+			},
+		},
+		{
+			name: "success resets the failures",
+			steps: []breakerStep{
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_OK, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				allowStep(true, CIRCUIT_CLOSED),
+			},
+		},
+		{
+			name: "failed probe opens the circuit again",
+			steps: []breakerStep{
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_OPEN),
+				expireStep(true, CIRCUIT_HALF_OPEN),
+				recordStep(TOOL_REQUEST_TIMEOUT, CIRCUIT_OPEN),
+				allowStep(false, CIRCUIT_OPEN),
+			},
+		},
+		{
+			name: "canceled probe",
+			steps: []breakerStep{
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_OPEN),
+				expireStep(true, CIRCUIT_HALF_OPEN),
+				recordStep(TOOL_REQUEST_CANCELED, CIRCUIT_OPEN),
+				// the cool-down is still expired, so the next request probes
+				allowStep(true, CIRCUIT_HALF_OPEN),
+				recordStep(TOOL_REQUEST_OK, CIRCUIT_CLOSED),
+			},
+		},
+		{
+			name: "canceled requests don't count",
+			steps: []breakerStep{
+				recordStep(TOOL_REQUEST_FAILED, CIRCUIT_CLOSED),
+				recordStep(TOOL_REQUEST_CANCELED, CIRCUIT_CLOSED),
+				allowStep(true, CIRCUIT_CLOSED),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := circuitBreakers{circuits: make(map[string]*circuit)}
+			for i, step := range test.steps {
+				if step.expire {
+					b.circuits["tool"].openedAt = time.Now().Add(-config.CoolDown)
+				}
+				if step.record != nil {
+					toolError := toolapi.NewToolError(toolapi.ERROR_CODE_TOOL_FAILED, "failed")
+					b.record(config, "tool", *step.record, toolError)
+				} else if allowed := b.allow(config, "tool"); allowed != step.wantAllow {
+					t.Fatalf("step %d: got allow %t, want %t", i, allowed, step.wantAllow)
+				}
+				state, _, _ := b.getState("tool")
+				if state != step.wantState {
+					t.Fatalf("step %d: got state %s, want %s", i, state, step.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestToolReportedErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    toolRequestStatus
+		wantErrorKind string
+	}{
+		{
+			name:       "result",
+			body:       `{"toolVersion": "1.0", "features": {}}`,
+			wantStatus: TOOL_REQUEST_OK,
+		},
+		{
+			name:          "timeout",
+			body:          `{"error": {"code": "timeout", "message": "timeout exceeded"}}`,
+			wantStatus:    TOOL_REQUEST_TIMEOUT,
+			wantErrorKind: TOOL_ERROR_TIMEOUT,
+		},
+		{
+			name:          "tool failed",
+			body:          `{"error": {"code": "tool_failed", "message": "exit status 1", "exitCode": 1}}`,
+			wantStatus:    TOOL_REQUEST_FAILED,
+			wantErrorKind: TOOL_ERROR_TOOL,
+		},
+		{
+			name:          "plain error message",
+			body:          `{"error": "tool crashed"}`,
+			wantStatus:    TOOL_REQUEST_FAILED,
+			wantErrorKind: TOOL_ERROR_TOOL,
+		},
+		{
+			name:          "canceled",
+			body:          `{"error": {"code": "canceled", "message": "request canceled"}}`,
+			wantStatus:    TOOL_REQUEST_CANCELED,
+			wantErrorKind: TOOL_ERROR_CANCELED,
+		},
+		{
+			name:          "unsupported file",
+			body:          `{"error": {"code": "unsupported", "message": "not a PDF file"}}`,
+			wantStatus:    TOOL_REQUEST_OK,
+			wantErrorKind: TOOL_ERROR_TOOL,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+			response, status := requestTool(
+				context.Background(),
+				ToolConfig{Id: "tool", Endpoint: server.URL},
+				"file.pdf",
+			)
+			if status != test.wantStatus {
+				t.Errorf("got status %d, want %d", status, test.wantStatus)
+			}
+			if response.errorKind != test.wantErrorKind {
+				t.Errorf("got error kind %q, want %q", response.errorKind, test.wantErrorKind)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxEntries is the maximum number of cached tool results. The least
+	// recently used entries are removed first.
+	MaxEntries int `yaml:"maxEntries"`
+	// MaxAge is the duration after which cached tool results expire, for
+	// example "168h". Zero means that entries don't expire.
+	MaxAge time.Duration `yaml:"maxAge"`
+	line   int
+}
+
+// TOOL_VERSION_REFRESH_INTERVAL is the interval in which the versions of the
+// tools are requested, so that cached results of replaced tools aren't used.
+const TOOL_VERSION_REFRESH_INTERVAL = 5 * time.Minute
+
+// resultCache stores tool results by the hash of the analyzed file, the tool
+// id and the tool version.
+//
+// The cache remembers the current version of every tool and only returns
+// entries for this version. The versions are requested from the tool services
+// at startup, after a reload of the configuration and periodically. A tool
+// result reporting a new version updates the version as well. When the
+// version of a tool changes, all entries of its old versions are removed.
+//
+// The entries are kept in a list ordered by their last use, so that the
+// least recently used entry is removed if the cache is full.
+type resultCache struct {
+	mu           sync.Mutex
+	entries      map[cacheKey]*list.Element
+	lru          *list.List
+	toolVersions map[string]string
+}
+
+type cacheKey struct {
+	fileHash    string
+	toolId      string
+	toolVersion string
+}
+
+type cacheEntry struct {
+	key       cacheKey
+	result    ToolResult
+	createdAt time.Time
+}
+
+var cache = newResultCache()
+
+func newResultCache() resultCache {
+	return resultCache{
+		entries:      make(map[cacheKey]*list.Element),
+		lru:          list.New(),
+		toolVersions: make(map[string]string),
+	}
+}
+
+// get returns the cached result of a tool for a file, if the cache contains an
+// unexpired entry for the current version of the tool.
+func (c *resultCache) get(config *CacheConfig, fileHash string, toolId string) (ToolResult, bool) {
+	if config == nil || !config.Enabled {
+		return ToolResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	toolVersion, ok := c.toolVersions[toolId]
+	if !ok {
+		return ToolResult{}, false
+	}
+	key := cacheKey{fileHash: fileHash, toolId: toolId, toolVersion: toolVersion}
+	element, ok := c.entries[key]
+	if !ok {
+		return ToolResult{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if config.MaxAge > 0 && time.Since(entry.createdAt) > config.MaxAge {
+		c.remove(element)
+		return ToolResult{}, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// put stores the result of a tool for a file. Results with errors are not
+// cached, because the error may be temporary.
+func (c *resultCache) put(config *CacheConfig, fileHash string, result ToolResult) {
+	if config == nil || !config.Enabled || result.Error != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setToolVersion(result.Id, result.ToolVersion)
+	key := cacheKey{
+		fileHash:    fileHash,
+		toolId:      result.Id,
+		toolVersion: result.ToolVersion,
+	}
+	entry := &cacheEntry{key: key, result: result, createdAt: time.Now()}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+	if config.MaxEntries > 0 {
+		for c.lru.Len() > config.MaxEntries {
+			c.remove(c.lru.Back())
+		}
+	}
+}
+
+// setToolVersion updates the current version of a tool and removes the
+// entries of its other versions. The caller must hold the lock.
+func (c *resultCache) setToolVersion(toolId string, toolVersion string) {
+	if version, ok := c.toolVersions[toolId]; ok && version == toolVersion {
+		return
+	}
+	c.toolVersions[toolId] = toolVersion
+	for key, element := range c.entries {
+		if key.toolId == toolId && key.toolVersion != toolVersion {
+			c.remove(element)
+		}
+	}
+}
+
+// forgetToolVersion removes the version of a tool, if it couldn't be
+// requested. The cached results of the tool are used again as soon as a
+// result confirms their version. The caller must hold the lock.
+func (c *resultCache) forgetToolVersion(toolId string) {
+	delete(c.toolVersions, toolId)
+}
+
+// remove deletes an entry from the cache. The caller must hold the lock.
+func (c *resultCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// RefreshToolVersions requests the versions of all tools that may be used
+// for analyses and updates the versions known by the cache. It does nothing
+// if the cache is disabled.
+func RefreshToolVersions(ctx context.Context, config *ServerConfig) {
+	if config.Cache == nil || !config.Cache.Enabled {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, toolConfig := range config.Tools {
+		if !toolConfig.Enabled && !config.IsFallback(toolConfig.Id) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.refreshToolVersion(ctx, toolConfig)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *resultCache) refreshToolVersion(ctx context.Context, toolConfig ToolConfig) {
+	baseEndpoint, err := getBaseEndpoint(toolConfig.Endpoint)
+	var version toolServiceVersion
+	if err == nil {
+		version, err = getToolServiceVersion(ctx, baseEndpoint)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Printf("unable to request version of tool %s: %v", toolConfig.Id, err)
+		c.forgetToolVersion(toolConfig.Id)
+		return
+	}
+	c.setToolVersion(toolConfig.Id, version.ToolVersion)
+}
+
+// InvalidateCache removes all cached tool results and returns the number of
+// removed entries.
+func InvalidateCache() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	count := cache.lru.Len()
+	clear(cache.entries)
+	cache.lru.Init()
+	return count
+}
+
+// InvalidateCacheForFile removes all cached tool results for the file with the
+// given SHA-256 hash and returns the number of removed entries.
+func InvalidateCacheForFile(fileHash string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	count := 0
+	for key, element := range cache.entries {
+		if key.fileHash == fileHash {
+			cache.remove(element)
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	config := &CacheConfig{Enabled: true, MaxEntries: 2}
+	c := newResultCache()
+	result := ToolResult{Id: "tool", ToolVersion: "1.0"}
+	c.put(config, "a", result)
+	c.put(config, "b", result)
+	// using a makes b the least recently used entry
+	if _, ok := c.get(config, "a", "tool"); !ok {
+		t.Fatal("entry a missing")
+	}
+	c.put(config, "c", result)
+	for hash, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(config, hash, "tool"); ok != want {
+			t.Errorf("got entry %s cached %t, want %t", hash, ok, want)
+		}
+	}
+	if c.lru.Len() != len(c.entries) {
+		t.Errorf("list contains %d entries, map contains %d", c.lru.Len(), len(c.entries))
+	}
+}
+
+func TestRefreshToolVersions(t *testing.T) {
+	toolVersion := "1.0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"toolVersion": "` + toolVersion + `"}`))
+	}))
+	defer server.Close()
+	config := &ServerConfig{
+		Cache: &CacheConfig{Enabled: true},
+		Tools: []ToolConfig{{Id: "tool", Enabled: true, Endpoint: server.URL + "/analyze"}},
+	}
+	InvalidateCache()
+	defer InvalidateCache()
+	cache.put(config.Cache, "a", ToolResult{Id: "tool", ToolVersion: "1.0"})
+	RefreshToolVersions(context.Background(), config)
+	if _, ok := cache.get(config.Cache, "a", "tool"); !ok {
+		t.Fatal("result of the current tool version missing")
+	}
+	// the tool was updated without reporting a result yet
+	toolVersion = "2.0"
+	RefreshToolVersions(context.Background(), config)
+	if _, ok := cache.get(config.Cache, "a", "tool"); ok {
+		t.Fatal("got result of an outdated tool version")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("got %d entries, want outdated entries to be removed", len(cache.entries))
+	}
+}
+
+func TestRunToolCachesResultWithoutTriggerFeatures(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"toolVersion": "1.0", "features": {"format:valid": {"value": true}}}`))
+	}))
+	defer server.Close()
+	toolConfig := newTestToolConfig(
+		"jhove",
+		0.75,
+		FeatureConfig{Key: "format:puid", ProvidedByTrigger: true},
+		FeatureConfig{Key: "format:valid"},
+	)
+	toolConfig.Endpoint = server.URL
+	request := AnalysisRequest{
+		Config:    &ServerConfig{Cache: &CacheConfig{Enabled: true}},
+		Filename:  "file.pdf",
+		Checksums: Checksums{CHECKSUM_SHA256: "a"},
+	}
+	InvalidateCache()
+	defer InvalidateCache()
+	pdfCause := &TriggerCause{
+		Matches: map[string]ToolFeatureValue{"format:puid": {Value: "fmt/19"}},
+	}
+	result := runTool(request, toolConfig, pdfCause)
+	if result.Cached || result.Features["format:puid"].Value != "fmt/19" {
+		t.Fatalf("got first result %+v, want the PUID of the trigger", result)
+	}
+	// the second trigger matched another feature
+	mimeTypeCause := &TriggerCause{
+		Matches: map[string]ToolFeatureValue{"format:mimeType": {Value: "application/pdf"}},
+	}
+	result = runTool(request, toolConfig, mimeTypeCause)
+	if !result.Cached {
+		t.Fatal("got uncached second result, want cached")
+	}
+	if _, ok := result.Features["format:puid"]; ok {
+		t.Errorf("got PUID %v of the first trigger in the cached result", result.Features["format:puid"].Value)
+	}
+	if result.Features["format:valid"].Value != true || result.TriggeredBy != mimeTypeCause {
+		t.Errorf("got cached result %+v, want the result of the tool with the second trigger", result)
+	}
+	if requests != 1 {
+		t.Errorf("got %d tool requests, want 1", requests)
+	}
+}
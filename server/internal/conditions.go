@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// FeatureCondition tests the values of a feature. All given tests must be
+// fulfilled:
+//   - regEx: the value is a string matching the regular expression
+//   - value: the value equals the given value
+//   - in: the value equals one of the given values
+//   - lt, gt, between: the value is a number less than, greater than or
+//     between (inclusive) the given numbers
+//   - exists: the feature has any value
+//   - absent: the feature has no value
+//   - not: the nested condition is not fulfilled
+//   - anyOf, allOf: one or all of the nested conditions are fulfilled
+//
+// Nested conditions without a feature test the feature of the enclosing
+// condition. If a feature has multiple values, for example from different
+// tools, one of the values must fulfill the tests.
+type FeatureCondition struct {
+	Feature string             `yaml:"feature" json:"feature,omitempty"`
+	RegEx   *string            `yaml:"regEx" json:"regEx,omitempty"`
+	Value   interface{}        `yaml:"value" json:"value,omitempty"`
+	In      []interface{}      `yaml:"in" json:"in,omitempty"`
+	Lt      *float64           `yaml:"lt" json:"lt,omitempty"`
+	Gt      *float64           `yaml:"gt" json:"gt,omitempty"`
+	Between []float64          `yaml:"between" json:"between,omitempty"`
+	Exists  bool               `yaml:"exists" json:"exists,omitempty"`
+	Absent  bool               `yaml:"absent" json:"absent,omitempty"`
+	Not     *FeatureCondition  `yaml:"not" json:"not,omitempty"`
+	AnyOf   []FeatureCondition `yaml:"anyOf" json:"anyOf,omitempty"`
+	AllOf   []FeatureCondition `yaml:"allOf" json:"allOf,omitempty"`
+	line    int
+}
+
+// MAX_CACHED_REGEXPS limits the number of compiled regular expressions kept in
+// memory, because inline configurations of api/merge may contain arbitrary
+// patterns.
+const MAX_CACHED_REGEXPS = 1000
+
+// regExCache contains the compiled regular expressions of the conditions by
+// pattern. It is filled on first use, so that conditions work whether the
+// configuration was validated or not.
+var regExCache = struct {
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// compileRegEx returns the compiled pattern or nil if the pattern is invalid.
+// The configuration validation reports invalid patterns beforehand.
+func compileRegEx(pattern string) *regexp.Regexp {
+	regExCache.mu.Lock()
+	defer regExCache.mu.Unlock()
+	regEx, ok := regExCache.compiled[pattern]
+	if ok {
+		return regEx
+	}
+	regEx, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("configuration error: invalid regular expression %q: %v", pattern, err)
+	}
+	if len(regExCache.compiled) < MAX_CACHED_REGEXPS {
+		regExCache.compiled[pattern] = regEx
+	}
+	return regEx
+}
+
+// featureLookup returns all values of a feature.
+type featureLookup func(key string) []ToolFeatureValue
+
+// lookupToolResult looks up features in the result of a single tool.
+func lookupToolResult(tr ToolResult) featureLookup {
+	return func(key string) []ToolFeatureValue {
+		v, ok := tr.Features[key]
+		if !ok {
+			return nil
+		}
+		return []ToolFeatureValue{v}
+	}
+}
+
+// lookupToolResults looks up features in the results of all tools and in the
+// features determined by Borg itself. The values are ordered by tool id.
+func lookupToolResults(
+	toolResults map[string]ToolResult,
+	fileFeatures map[string]ToolFeatureValue,
+) featureLookup {
+	toolIds := slices.Sorted(maps.Keys(toolResults))
+	return func(key string) []ToolFeatureValue {
+		var values []ToolFeatureValue
+		for _, id := range toolIds {
+			v, ok := toolResults[id].Features[key]
+			if ok {
+				values = append(values, v)
+			}
+		}
+		v, ok := fileFeatures[key]
+		if ok {
+			values = append(values, v)
+		}
+		return values
+	}
+}
+
+// lookupFeatureSet looks up features in a merged feature set and in the
+// optional features determined by Borg itself.
+func lookupFeatureSet(s FeatureSet, fileFeatures map[string]ToolFeatureValue) featureLookup {
+	return func(key string) []ToolFeatureValue {
+		v, ok := s.Features[key]
+		if ok {
+			return []ToolFeatureValue{{Value: v.Value, Label: v.Label}}
+		}
+		fileFeature, ok := fileFeatures[key]
+		if ok {
+			return []ToolFeatureValue{fileFeature}
+		}
+		return nil
+	}
+}
+
+// IsFulfilled evaluates the condition. The values that fulfilled the tests
+// are added to matches, which may be nil.
+func (c *FeatureCondition) IsFulfilled(
+	lookup featureLookup,
+	matches map[string]ToolFeatureValue,
+) bool {
+	return c.evaluate(lookup, "", matches)
+}
+
+func (c *FeatureCondition) evaluate(
+	lookup featureLookup,
+	feature string,
+	matches map[string]ToolFeatureValue,
+) bool {
+	if c.Feature != "" {
+		feature = c.Feature
+	}
+	// negated conditions never provide matches
+	if c.Not != nil && c.Not.evaluate(lookup, feature, nil) {
+		return false
+	}
+	for _, nested := range c.AllOf {
+		if !nested.evaluate(lookup, feature, matches) {
+			return false
+		}
+	}
+	if len(c.AnyOf) > 0 {
+		isFulfilled := false
+		for _, nested := range c.AnyOf {
+			// only the matches of the fulfilled condition are kept
+			nestedMatches := make(map[string]ToolFeatureValue)
+			if nested.evaluate(lookup, feature, nestedMatches) {
+				if matches != nil {
+					maps.Copy(matches, nestedMatches)
+				}
+				isFulfilled = true
+				break
+			}
+		}
+		if !isFulfilled {
+			return false
+		}
+	}
+	if !c.testsValue() && !c.Exists && !c.Absent {
+		return true
+	}
+	values := lookup(feature)
+	if c.Absent {
+		return len(values) == 0
+	}
+	for _, v := range values {
+		if c.isFulfilledBy(v.Value) {
+			if matches != nil {
+				matches[feature] = v
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// testsValue reports whether the condition contains tests for the value of
+// the feature.
+func (c *FeatureCondition) testsValue() bool {
+	return c.RegEx != nil || c.Value != nil || len(c.In) > 0 || c.testsNumber()
+}
+
+func (c *FeatureCondition) testsNumber() bool {
+	return c.Lt != nil || c.Gt != nil || len(c.Between) > 0
+}
+
+func (c *FeatureCondition) isFulfilledBy(value interface{}) bool {
+	if c.RegEx != nil {
+		// regular expressions only match string-typed feature values
+		v, ok := value.(string)
+		if !ok {
+			return false
+		}
+		regEx := compileRegEx(*c.RegEx)
+		if regEx == nil || !regEx.MatchString(v) {
+			return false
+		}
+	}
+	if c.Value != nil && !isEqualValue(value, c.Value) {
+		return false
+	}
+	if len(c.In) > 0 && !slices.ContainsFunc(c.In, func(v interface{}) bool {
+		return isEqualValue(value, v)
+	}) {
+		return false
+	}
+	if c.testsNumber() {
+		n, ok := toNumber(value)
+		if !ok {
+			return false
+		}
+		if c.Lt != nil && !(n < *c.Lt) {
+			return false
+		}
+		if c.Gt != nil && !(n > *c.Gt) {
+			return false
+		}
+		if len(c.Between) == 2 && (n < c.Between[0] || n > c.Between[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isEqualValue compares feature values. Numbers are compared by value, since
+// the configuration contains integers while tools provide floating point
+// numbers.
+func isEqualValue(v1 interface{}, v2 interface{}) bool {
+	n1, ok1 := toNumber(v1)
+	n2, ok2 := toNumber(v2)
+	if ok1 && ok2 {
+		return n1 == n2
+	}
+	if ok1 || ok2 {
+		return false
+	}
+	switch v1.(type) {
+	case string, bool:
+		return v1 == v2
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
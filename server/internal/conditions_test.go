@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func floatPointer(f float64) *float64 {
+	return &f
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestFeatureConditionIsFulfilled(t *testing.T) {
+	features := map[string]interface{}{
+		"format:puid":     "fmt/19",
+		"format:mimeType": "application/pdf",
+		"format:valid":    true,
+		"int":             10,
+		"float":           10.5,
+		"numericString":   "10",
+	}
+	tests := []struct {
+		name      string
+		condition FeatureCondition
+		want      bool
+	}{
+		{
+			name:      "lt with int value",
+			condition: FeatureCondition{Feature: "int", Lt: floatPointer(11)},
+			want:      true,
+		},
+		{
+			name:      "lt is exclusive",
+			condition: FeatureCondition{Feature: "int", Lt: floatPointer(10)},
+			want:      false,
+		},
+		{
+			name:      "gt with float value",
+			condition: FeatureCondition{Feature: "float", Gt: floatPointer(10)},
+			want:      true,
+		},
+		{
+			name:      "gt is exclusive",
+			condition: FeatureCondition{Feature: "float", Gt: floatPointer(10.5)},
+			want:      false,
+		},
+		{
+			name:      "lt and gt",
+			condition: FeatureCondition{Feature: "float", Gt: floatPointer(10), Lt: floatPointer(11)},
+			want:      true,
+		},
+		{
+			name:      "string value isn't compared as number",
+			condition: FeatureCondition{Feature: "numericString", Lt: floatPointer(100)},
+			want:      false,
+		},
+		{
+			name:      "between lower bound",
+			condition: FeatureCondition{Feature: "int", Between: []float64{10, 20}},
+			want:      true,
+		},
+		{
+			name:      "between upper bound",
+			condition: FeatureCondition{Feature: "float", Between: []float64{1, 10.5}},
+			want:      true,
+		},
+		{
+			name:      "below between",
+			condition: FeatureCondition{Feature: "int", Between: []float64{10.5, 20}},
+			want:      false,
+		},
+		{
+			name:      "above between",
+			condition: FeatureCondition{Feature: "float", Between: []float64{1, 10}},
+			want:      false,
+		},
+		{
+			name:      "int value equals float",
+			condition: FeatureCondition{Feature: "int", Value: 10.0},
+			want:      true,
+		},
+		{
+			name:      "string value doesn't equal number",
+			condition: FeatureCondition{Feature: "numericString", Value: 10},
+			want:      false,
+		},
+		{
+			name:      "in",
+			condition: FeatureCondition{Feature: "format:puid", In: []interface{}{"fmt/18", "fmt/19"}},
+			want:      true,
+		},
+		{
+			name:      "not in",
+			condition: FeatureCondition{Feature: "format:puid", In: []interface{}{"fmt/20"}},
+			want:      false,
+		},
+		{
+			name:      "exists",
+			condition: FeatureCondition{Feature: "format:valid", Exists: true},
+			want:      true,
+		},
+		{
+			name:      "exists for missing feature",
+			condition: FeatureCondition{Feature: "format:version", Exists: true},
+			want:      false,
+		},
+		{
+			name:      "absent",
+			condition: FeatureCondition{Feature: "format:version", Absent: true},
+			want:      true,
+		},
+		{
+			name: "not absent",
+			condition: FeatureCondition{
+				Feature: "format:puid",
+				Not:     &FeatureCondition{Absent: true},
+			},
+			want: true,
+		},
+		{
+			name: "not absent for missing feature",
+			condition: FeatureCondition{
+				Feature: "format:version",
+				Not:     &FeatureCondition{Absent: true},
+			},
+			want: false,
+		},
+		{
+			name: "not with own feature",
+			condition: FeatureCondition{
+				Not: &FeatureCondition{Feature: "format:version", Absent: true},
+			},
+			want: false,
+		},
+		{
+			name: "anyOf",
+			condition: FeatureCondition{
+				Feature: "format:puid",
+				AnyOf: []FeatureCondition{
+					{Value: "fmt/18"},
+					{RegEx: stringPointer("^fmt/1[0-9]$")},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "allOf",
+			condition: FeatureCondition{
+				AllOf: []FeatureCondition{
+					{Feature: "format:puid", Value: "fmt/19"},
+					{Feature: "format:valid", Value: false},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "nested groups",
+			condition: FeatureCondition{
+				AnyOf: []FeatureCondition{
+					{
+						AllOf: []FeatureCondition{
+							{Feature: "format:mimeType", Value: "application/pdf"},
+							{Feature: "int", Gt: floatPointer(100)},
+						},
+					},
+					{
+						AllOf: []FeatureCondition{
+							{Feature: "format:mimeType", Value: "application/pdf"},
+							{Feature: "float", Not: &FeatureCondition{Between: []float64{0, 1}}},
+						},
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "not of nested group",
+			condition: FeatureCondition{
+				Not: &FeatureCondition{
+					AnyOf: []FeatureCondition{
+						{Feature: "format:version", Exists: true},
+						{Feature: "int", In: []interface{}{5, 10}},
+					},
+				},
+			},
+			want: false,
+		},
+	}
+	result := newTestToolResult("tool", features)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.condition.IsFulfilled(lookupToolResult(result), nil)
+			if got != test.want {
+				t.Errorf("got fulfilled %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFeatureConditionMatches(t *testing.T) {
+	result := newTestToolResult("tool", map[string]interface{}{
+		"format:puid":     "fmt/19",
+		"format:mimeType": "application/pdf",
+	})
+	condition := FeatureCondition{
+		AllOf: []FeatureCondition{
+			{Feature: "format:puid", AnyOf: []FeatureCondition{{Value: "fmt/20"}, {Value: "fmt/19"}}},
+			// negated conditions don't provide matches
+			{Feature: "format:mimeType", Not: &FeatureCondition{Value: "image/png"}},
+		},
+	}
+	matches := make(map[string]ToolFeatureValue)
+	if !condition.IsFulfilled(lookupToolResult(result), matches) {
+		t.Fatal("got condition not fulfilled, want fulfilled")
+	}
+	want := map[string]ToolFeatureValue{"format:puid": {Value: "fmt/19"}}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("got matches %v, want %v", matches, want)
+	}
+}
@@ -1,10 +1,14 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,10 +16,37 @@ import (
 type ServerConfig struct {
 	Tools             []ToolConfig       `yaml:"tools"`
 	FileIdentityRules []FileIdentityRule `yaml:"fileIdentity"`
+	// ReferenceRoots are directories whose files can be analyzed by path
+	// without uploading them. The directories must be located in the file
+	// store, so that the tools can access them.
+	ReferenceRoots []string `yaml:"referenceRoots"`
+	// Cache configures the cache for tool results. Results are not cached if
+	// the option is missing.
+	Cache *CacheConfig `yaml:"cache"`
+	// Archive limits the extraction of uploaded archives. The default limits
+	// apply if the option is missing.
+	Archive *ArchiveLimits `yaml:"archive"`
+	// Profiles are named selections of tools, which can be chosen per request.
+	Profiles []AnalysisProfile `yaml:"profiles"`
+	// DefaultProfile is used for requests without a profile. All enabled
+	// tools are used if the option is missing.
+	DefaultProfile string `yaml:"defaultProfile"`
+	// TriggerChaining limits the rounds of triggered tools. Only a single
+	// round is run if the option is missing.
+	TriggerChaining *TriggerChainingConfig `yaml:"triggerChaining"`
+	// CircuitBreaker skips tools that failed repeatedly. Tools are always
+	// requested if the option is missing.
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuitBreaker"`
+	// Revision identifies the content of the configuration file. It is the
+	// beginning of the SHA-256 digest of the file.
+	Revision string `yaml:"-"`
+	// path is the file the configuration was read from.
+	path string
 }
 
 type FileIdentityRule struct {
 	Conditions []FeatureCondition `yaml:"conditions"`
+	line       int
 }
 
 type LocalizationResource struct {
@@ -29,42 +60,206 @@ type ToolConfig struct {
 	Endpoint   string           `yaml:"endpoint"`
 	Triggers   []Trigger        `yaml:"triggers"`
 	FeatureSet FeatureSetConfig `yaml:"featureSet"`
+	// Timeout is passed to the tool wrapper, which kills the tool as soon as
+	// it is exceeded. DEFAULT_TOOL_TIMEOUT is used if the option is missing.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of additional requests if the tool wrapper can't
+	// be reached or responds with a server error.
+	Retries int `yaml:"retries"`
+	// Backoff is the delay before the first retry. It doubles with every
+	// further retry.
+	Backoff time.Duration `yaml:"backoff"`
+	// Fallback is the id of a tool that runs instead if this tool fails. The
+	// fallback tool may be disabled, so that it only runs as a fallback.
+	Fallback string `yaml:"fallback"`
+	// Required means that the server isn't ready while the tool is
+	// unavailable.
+	Required bool `yaml:"required"`
+	// ValidationProfile names the profile or module a validator checks the
+	// file against, for example "PDF/A-1b". It is reported in the summary.
+	ValidationProfile string `yaml:"validationProfile"`
+	line              int
 }
 
-func (t *ToolConfig) IsTriggered(toolResults map[string]ToolResult) (bool, map[string]ToolFeatureValue) {
+// getFallback returns the fallback of a tool, unless the fallback is excluded
+// from the analysis or already runs together with the tool.
+func (c *ServerConfig) getFallback(
+	selection ToolSelection,
+	toolConfig ToolConfig,
+	tools []ToolConfig,
+) (ToolConfig, bool) {
+	if toolConfig.Fallback == "" {
+		return ToolConfig{}, false
+	}
+	fallback, ok := c.getToolConfig(toolConfig.Fallback)
+	if !ok {
+		return ToolConfig{}, false
+	}
+	// disabled tools are only skipped if the profile or request excludes them
+	enabledFallback := fallback
+	enabledFallback.Enabled = true
+	if c.getSkipReason(selection, enabledFallback) != "" {
+		return ToolConfig{}, false
+	}
+	isRunning := slices.ContainsFunc(tools, func(tc ToolConfig) bool {
+		return tc.Id == fallback.Id
+	})
+	return fallback, !isRunning
+}
+
+// IsFallback reports whether the tool is the fallback of an enabled tool, so
+// that it is used even if it is disabled itself.
+func (c *ServerConfig) IsFallback(id string) bool {
+	return slices.ContainsFunc(c.Tools, func(tc ToolConfig) bool {
+		return tc.Enabled && tc.Fallback == id
+	})
+}
+
+// getTimeout returns the timeout of the tool.
+func (t *ToolConfig) getTimeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return DEFAULT_TOOL_TIMEOUT
+}
+
+func (t *ToolConfig) IsTriggered(input triggerInput) (bool, *TriggerCause) {
 	for _, t := range t.Triggers {
-		isTriggered, matches := t.IsTriggered(toolResults)
+		isTriggered, cause := t.IsTriggered(input)
 		if isTriggered {
-			return true, matches
+			return true, cause
 		}
 	}
 	return false, nil
 }
 
+// GetToolIds returns the ids of all enabled tools in the order of the server
+// configuration.
+func (c *ServerConfig) GetToolIds() []string {
+	var ids []string
+	for _, tc := range c.Tools {
+		if tc.Enabled {
+			ids = append(ids, tc.Id)
+		}
+	}
+	return ids
+}
+
+// TriggerChainingConfig limits the execution of triggered tools. The triggers
+// are evaluated in rounds against the results of all previous rounds, so that
+// triggered tools can trigger further tools.
+type TriggerChainingConfig struct {
+	// MaxRounds is the maximum number of rounds after the identification.
+	MaxRounds int `yaml:"maxRounds"`
+	// MaxToolRuns is the maximum number of runs of a single tool in an
+	// analysis. A tool runs again if it is triggered by other feature values
+	// than before. The last result of the tool is used.
+	MaxToolRuns int `yaml:"maxToolRuns"`
+	line        int
+}
+
+// getTriggerLimits returns the maximum number of trigger rounds and runs of a
+// single tool.
+func (c *ServerConfig) getTriggerLimits() (maxRounds int, maxToolRuns int) {
+	maxRounds, maxToolRuns = 1, 1
+	if c.TriggerChaining != nil {
+		maxRounds = max(c.TriggerChaining.MaxRounds, 1)
+		maxToolRuns = max(c.TriggerChaining.MaxToolRuns, 1)
+	}
+	return
+}
+
+// GetToolOrder returns the ids of the tools with a result in the order of the
+// server configuration.
+func (c *ServerConfig) GetToolOrder(results map[string]ToolResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, tc := range c.Tools {
+		if _, ok := results[tc.Id]; ok {
+			ids = append(ids, tc.Id)
+		}
+	}
+	return ids
+}
+
+const (
+	// TRIGGER_MODE_TOOL_RESULTS evaluates the conditions of a trigger against
+	// the results of all tools. A single tool can fulfill a condition.
+	TRIGGER_MODE_TOOL_RESULTS = "toolResults"
+	// TRIGGER_MODE_FEATURE_SETS evaluates the conditions of a trigger against
+	// the merged feature sets whose score reaches the minimum score.
+	TRIGGER_MODE_FEATURE_SETS = "featureSets"
+)
+
 type Trigger struct {
-	Conditions []FeatureCondition `yaml:"conditions"`
+	Conditions []FeatureCondition `yaml:"conditions" json:"conditions"`
+	// Mode is either TRIGGER_MODE_TOOL_RESULTS, the default, or
+	// TRIGGER_MODE_FEATURE_SETS.
+	Mode string `yaml:"mode" json:"mode"`
+	// MinScore is the minimum score of the feature sets evaluated in the mode
+	// TRIGGER_MODE_FEATURE_SETS.
+	MinScore float64 `yaml:"minScore" json:"minScore"`
+	line     int
 }
 
-func (t *Trigger) IsTriggered(toolResults map[string]ToolResult) (bool, map[string]ToolFeatureValue) {
-	matches := make(map[string]ToolFeatureValue)
-	for _, condition := range t.Conditions {
-		isFulFilled := false
-		for _, toolResult := range toolResults {
-			v, ok := toolResult.Features[condition.Feature]
-			if !ok {
-				continue
-			}
-			if condition.IsFulfilled(v.Value) {
-				matches[condition.Feature] = v
-				isFulFilled = true
-				break
+// triggerInput contains everything the triggers of a round are evaluated
+// against.
+type triggerInput struct {
+	toolResults  map[string]ToolResult
+	featureSets  []FeatureSet
+	fileFeatures map[string]ToolFeatureValue
+}
+
+// TriggerCause describes why a tool was triggered.
+type TriggerCause struct {
+	Mode string `json:"mode"`
+	// Matches are the feature values that fulfilled the conditions.
+	Matches map[string]ToolFeatureValue `json:"matches"`
+	// FeatureSet is the merged feature set that fulfilled the conditions in
+	// the mode TRIGGER_MODE_FEATURE_SETS.
+	FeatureSet *FeatureSet `json:"featureSet,omitempty"`
+}
+
+func (t *Trigger) IsTriggered(input triggerInput) (bool, *TriggerCause) {
+	if t.Mode != TRIGGER_MODE_FEATURE_SETS {
+		lookup := lookupToolResults(input.toolResults, input.fileFeatures)
+		matches, ok := t.areConditionsFulfilled(lookup)
+		if !ok {
+			return false, nil
+		}
+		return true, &TriggerCause{Mode: TRIGGER_MODE_TOOL_RESULTS, Matches: matches}
+	}
+	for _, set := range input.featureSets {
+		if set.Score < t.MinScore {
+			continue
+		}
+		matches, ok := t.areConditionsFulfilled(lookupFeatureSet(set, input.fileFeatures))
+		if ok {
+			return true, &TriggerCause{
+				Mode:       TRIGGER_MODE_FEATURE_SETS,
+				Matches:    matches,
+				FeatureSet: &set,
 			}
 		}
-		if !isFulFilled {
-			return false, matches
+	}
+	return false, nil
+}
+
+func (t *Trigger) areConditionsFulfilled(lookup featureLookup) (map[string]ToolFeatureValue, bool) {
+	matches := make(map[string]ToolFeatureValue)
+	for _, condition := range t.Conditions {
+		if !condition.IsFulfilled(lookup, matches) {
+			return nil, false
 		}
 	}
-	return true, matches
+	return matches, true
+}
+
+// usesFeatureSets reports whether a trigger of the tool evaluates the merged
+// feature sets.
+func (t *ToolConfig) usesFeatureSets() bool {
+	return slices.ContainsFunc(t.Triggers, func(trigger Trigger) bool {
+		return trigger.Mode == TRIGGER_MODE_FEATURE_SETS
+	})
 }
 
 type FeatureSetConfig struct {
@@ -76,6 +271,17 @@ func (c *FeatureSetConfig) AreMergeable(
 	fs1 map[string]MergeFeatureValue,
 	fs2 map[string]ToolFeatureValue,
 ) (isFulfilled bool, mergeModifier float64) {
+	isFulfilled, mergeModifier, _ = c.checkMergeConditions(fs1, fs2)
+	return
+}
+
+// checkMergeConditions evaluates the merge conditions like AreMergeable and
+// additionally returns the outcome of every evaluated condition. The
+// evaluation stops at the first rejected condition.
+func (c *FeatureSetConfig) checkMergeConditions(
+	fs1 map[string]MergeFeatureValue,
+	fs2 map[string]ToolFeatureValue,
+) (isFulfilled bool, mergeModifier float64, outcomes []MergeConditionOutcome) {
 	// The merge is always possible if the origin set is empty.
 	if len(fs1) == 0 {
 		isFulfilled = true
@@ -84,6 +290,16 @@ func (c *FeatureSetConfig) AreMergeable(
 	for _, feature := range c.Features {
 		if feature.MergeCondition != nil {
 			ok, strongLink := feature.MergeCondition.IsFulfilled(feature.Key, fs1, fs2)
+			outcome := MergeConditionOutcome{Feature: feature.Key}
+			switch {
+			case !ok:
+				outcome.Outcome = MERGE_LINK_REJECTED
+			case strongLink:
+				outcome.Outcome = MERGE_LINK_STRONG
+			default:
+				outcome.Outcome = MERGE_LINK_WEAK
+			}
+			outcomes = append(outcomes, outcome)
 			if !ok {
 				isFulfilled = false
 				return
@@ -111,21 +327,24 @@ func (c *FeatureSetConfig) GetFeatureConfig(key string) (FeatureConfig, bool) {
 }
 
 type FeatureConfig struct {
-	Key               string          `yaml:"key"`
-	MergeOrder        uint            `yaml:"mergeOrder"`
-	ProvidedByTrigger bool            `yaml:"providedByTrigger"`
-	MergeCondition    *MergeCondition `yaml:"mergeCondition"`
+	Key               string          `yaml:"key" json:"key"`
+	MergeOrder        uint            `yaml:"mergeOrder" json:"mergeOrder"`
+	ProvidedByTrigger bool            `yaml:"providedByTrigger" json:"providedByTrigger"`
+	MergeCondition    *MergeCondition `yaml:"mergeCondition" json:"mergeCondition"`
+	line              int
 }
 
 type MergeCondition struct {
-	ExactMatch bool    `yaml:"exactMatch"`
-	ValueRegEx *string `yaml:"valueRegEx"`
+	ExactMatch bool    `yaml:"exactMatch" json:"exactMatch"`
+	ValueRegEx *string `yaml:"valueRegEx" json:"valueRegEx"`
+	line       int
 }
 
 type Weight struct {
-	Default            float64             `yaml:"default"`
-	ConditionalWeights []ConditionalWeight `yaml:"conditional"`
-	ProvidedByTool     bool                `yaml:"providedByTool"`
+	Default            float64             `yaml:"default" json:"default"`
+	ConditionalWeights []ConditionalWeight `yaml:"conditional" json:"conditional"`
+	ProvidedByTool     bool                `yaml:"providedByTool" json:"providedByTool"`
+	line               int
 }
 
 // priority of different providers:
@@ -133,60 +352,46 @@ type Weight struct {
 //   - 2. tool provided weight
 //   - 3. default weight
 func (w *Weight) GetWeight(tr ToolResult) float64 {
+	weight, _ := w.getWeightWithSource(tr)
+	return weight
+}
+
+// getWeightWithSource returns the weight and which provider determined it.
+func (w *Weight) getWeightWithSource(tr ToolResult) (float64, string) {
 	for _, cw := range w.ConditionalWeights {
 		if cw.IsFulfilled(tr) {
-			return cw.Value
+			return cw.Value, WEIGHT_SOURCE_CONDITIONAL
 		}
 	}
 	if w.ProvidedByTool {
-		if tr.Score == nil {
-			errorMessage := "configuration error: a tool provided weight was set "
-			errorMessage += "for a tool that does not support this option"
-			log.Fatal(errorMessage)
+		if tr.Score != nil {
+			return *tr.Score, WEIGHT_SOURCE_TOOL
 		}
-		return *tr.Score
+		log.Printf(
+			"configuration error: a tool provided weight was set for tool %s, "+
+				"which does not support this option",
+			tr.Id,
+		)
 	}
-	return w.Default
+	return w.Default, WEIGHT_SOURCE_DEFAULT
 }
 
 type ConditionalWeight struct {
-	Value      float64            `yaml:"value"`
-	Conditions []FeatureCondition `yaml:"conditions"`
+	Value      float64            `yaml:"value" json:"value"`
+	Conditions []FeatureCondition `yaml:"conditions" json:"conditions"`
+	line       int
 }
 
 func (w *ConditionalWeight) IsFulfilled(tr ToolResult) bool {
+	lookup := lookupToolResult(tr)
 	for _, c := range w.Conditions {
-		v, ok := tr.Features[c.Feature]
-		if !ok {
-			return false
-		}
-		if !c.IsFulfilled(v.Value) {
+		if !c.IsFulfilled(lookup, nil) {
 			return false
 		}
 	}
 	return true
 }
 
-type FeatureCondition struct {
-	Feature string      `yaml:"feature"`
-	RegEx   *string     `yaml:"regEx"`
-	Value   interface{} `yaml:"value"`
-}
-
-func (c *FeatureCondition) IsFulfilled(value interface{}) bool {
-	if c.RegEx != nil {
-		regEx := regexp.MustCompile(*c.RegEx)
-		v, ok := value.(string)
-		if !ok {
-			log.Fatal("regular expression condition requires a string-typed feature value")
-		}
-		return regEx.MatchString(v)
-	} else if c.Value != nil {
-		return value == c.Value
-	}
-	return false
-}
-
 func (c *MergeCondition) IsFulfilled(featureKey string, fs1 map[string]MergeFeatureValue, fs2 map[string]ToolFeatureValue) (isFulfilled bool, strongLink bool) {
 	// if the second feature sets doesn't contain any values
 	// the first feature set can be empty if merging against an empty set
@@ -204,19 +409,25 @@ func (c *MergeCondition) IsFulfilled(featureKey string, fs1 map[string]MergeFeat
 	}
 	// if value extraction regular expression is configured
 	if c.ValueRegEx != nil {
-		regEx := regexp.MustCompile(*c.ValueRegEx)
 		// extract comparable values from feature strings
 		s1, ok1 := fv1.Value.(string)
 		s2, ok2 := fv2.Value.(string)
 		if !ok1 || !ok2 {
-			errorMessage := fmt.Sprintf(
+			log.Printf(
 				"configuration error: "+
 					"used value extraction string on non string value for key {%s}",
-				featureKey)
-			log.Fatal(errorMessage)
+				featureKey,
+			)
+			isFulfilled = false
+			return
 		}
-		m1 := regEx.FindStringSubmatch(s1)
-		m2 := regEx.FindStringSubmatch(s2)
+		valueRegEx := compileRegEx(*c.ValueRegEx)
+		if valueRegEx == nil {
+			isFulfilled = false
+			return
+		}
+		m1 := valueRegEx.FindStringSubmatch(s1)
+		m2 := valueRegEx.FindStringSubmatch(s2)
 		if len(m1) != 2 || len(m2) != 2 {
 			isFulfilled = false
 			return
@@ -236,17 +447,56 @@ func (c *MergeCondition) IsFulfilled(featureKey string, fs1 map[string]MergeFeat
 	return
 }
 
-var serverConfig ServerConfig
+// CONFIG_PATH is the location of the server configuration.
+const CONFIG_PATH = "config/server_config.yml"
+
+// serverConfig is the current server configuration. It is replaced as a
+// whole when the configuration is reloaded, so that running analyses can keep
+// using the configuration they started with.
+var serverConfig atomic.Pointer[ServerConfig]
 
+// ParseConfig reads and validates the server configuration. The server can't
+// start with an invalid configuration, so all errors are fatal.
 func ParseConfig() {
-	bytes, err := os.ReadFile("config/server_config.yml")
+	config, err := LoadConfig(CONFIG_PATH)
 	if err != nil {
-		log.Fatal("server config not readable\n" + err.Error())
+		log.Fatal("server config invalid\n" + err.Error())
 	}
+	SetConfig(&config)
+}
+
+// LoadConfig reads the server configuration from a file and validates it.
+func LoadConfig(path string) (ServerConfig, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return ServerConfig{}, fmt.Errorf("server config not readable: %w", err)
+	}
+	return ParseConfigData(bytes, path)
+}
+
+// ParseConfigData parses and validates a server configuration in YAML. The
+// path names the origin of the configuration in validation errors.
+func ParseConfigData(bytes []byte, path string) (ServerConfig, error) {
 	var config ServerConfig
-	err = yaml.Unmarshal(bytes, &config)
+	err := yaml.Unmarshal(bytes, &config)
 	if err != nil {
-		log.Fatal("server config couldn't be parsed\n" + err.Error())
+		return config, fmt.Errorf("server config couldn't be parsed: %w", err)
 	}
-	serverConfig = config
+	digest := sha256.Sum256(bytes)
+	config.Revision = hex.EncodeToString(digest[:])[:12]
+	config.path = path
+	err = config.Validate()
+	return config, err
+}
+
+// GetConfig returns the current server configuration. The returned
+// configuration must not be modified.
+func GetConfig() *ServerConfig {
+	return serverConfig.Load()
+}
+
+// SetConfig replaces the current server configuration. The configuration must
+// be validated before.
+func SetConfig(config *ServerConfig) {
+	serverConfig.Store(config)
 }
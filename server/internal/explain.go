@@ -0,0 +1,143 @@
+package internal
+
+import "slices"
+
+// Outcomes of a merge condition.
+const (
+	// MERGE_LINK_STRONG means that both sets have equal values for the feature
+	// of the condition, which increases the score of the merged set.
+	MERGE_LINK_STRONG = "strongLink"
+	// MERGE_LINK_WEAK means that the condition doesn't prevent the merge,
+	// because one of the sets lacks the feature.
+	MERGE_LINK_WEAK     = "weakLink"
+	MERGE_LINK_REJECTED = "rejected"
+)
+
+// Sources of the weight of a tool result.
+const (
+	WEIGHT_SOURCE_CONDITIONAL = "conditional"
+	WEIGHT_SOURCE_TOOL        = "toolProvided"
+	WEIGHT_SOURCE_DEFAULT     = "default"
+)
+
+// Reasons why a tool result wasn't merged into a set.
+const (
+	MERGE_SKIPPED_TOOL_ERROR = "toolError"
+	// MERGE_SKIPPED_REJECTED means that a merge condition of the new tool was
+	// rejected.
+	MERGE_SKIPPED_REJECTED = "conditionRejected"
+	// MERGE_SKIPPED_NO_STRONG_LINK means that no merge condition was a strong
+	// link.
+	MERGE_SKIPPED_NO_STRONG_LINK = "noStrongLink"
+)
+
+// MergeTrace explains how the feature sets of an analysis were merged. It is
+// only recorded if the client requests it.
+type MergeTrace struct {
+	// Attempts contains every tool result that was tried to merge, grouped by
+	// the origin of the set.
+	Attempts []MergeAttempt `json:"attempts"`
+	// Duplicates are the sets removed, because another set is supported by
+	// the same tools.
+	Duplicates []DuplicateSet `json:"duplicates"`
+	// Normalization describes how the scores were scaled to a total of 1.
+	Normalization ScoreNormalization `json:"normalization"`
+	// FileIdentityRule is nil if no file identity rule fired.
+	FileIdentityRule *FiredFileIdentityRule `json:"fileIdentityRule"`
+}
+
+type MergeAttempt struct {
+	// OriginToolId is the tool whose result the set started with.
+	OriginToolId string `json:"originToolId"`
+	ToolId       string `json:"toolId"`
+	Merged       bool   `json:"merged"`
+	// SkipReason tells why the result wasn't merged.
+	SkipReason string `json:"skipReason,omitempty"`
+	// Conditions contains the outcome of every evaluated merge condition. The
+	// conditions of the already merged tools are evaluated as well, up to the
+	// first tool whose conditions aren't met. They don't prevent the merge.
+	Conditions    []MergeConditionOutcome `json:"conditions"`
+	MergeModifier float64                 `json:"mergeModifier"`
+	// Weight and WeightSource are only set if the result was merged.
+	Weight       float64 `json:"weight"`
+	WeightSource string  `json:"weightSource,omitempty"`
+	// ScoreBefore and ScoreAfter are the accumulated score of the set.
+	ScoreBefore float64 `json:"scoreBefore"`
+	ScoreAfter  float64 `json:"scoreAfter"`
+}
+
+type MergeConditionOutcome struct {
+	// ConfigToolId is the tool whose configuration contains the condition.
+	ConfigToolId string `json:"configToolId"`
+	Feature      string `json:"feature"`
+	Outcome      string `json:"outcome"`
+}
+
+type DuplicateSet struct {
+	SupportingTools []string `json:"supportingTools"`
+	Score           float64  `json:"score"`
+	// KeptScore is the score of the remaining set.
+	KeptScore float64 `json:"keptScore"`
+}
+
+type ScoreNormalization struct {
+	TotalScore float64         `json:"totalScore"`
+	Sets       []NormalizedSet `json:"sets"`
+}
+
+type NormalizedSet struct {
+	SupportingTools []string `json:"supportingTools"`
+	ScoreBefore     float64  `json:"scoreBefore"`
+	ScoreAfter      float64  `json:"scoreAfter"`
+}
+
+type FiredFileIdentityRule struct {
+	// Index is the position of the rule in the server configuration.
+	Index           int      `json:"index"`
+	Line            int      `json:"line"`
+	SupportingTools []string `json:"supportingTools"`
+}
+
+// The methods of MergeTrace do nothing if the trace is nil, so that the merge
+// doesn't need to check whether the client requested the trace.
+
+func (t *MergeTrace) addAttempt(attempt MergeAttempt) {
+	if t == nil {
+		return
+	}
+	t.Attempts = append(t.Attempts, attempt)
+}
+
+func (t *MergeTrace) addDuplicate(removed FeatureSet, kept FeatureSet) {
+	if t == nil {
+		return
+	}
+	t.Duplicates = append(t.Duplicates, DuplicateSet{
+		SupportingTools: slices.Clone(removed.SupportingTools),
+		Score:           removed.Score,
+		KeptScore:       kept.Score,
+	})
+}
+
+func (t *MergeTrace) addNormalization(totalScore float64, before FeatureSet, after FeatureSet) {
+	if t == nil {
+		return
+	}
+	t.Normalization.TotalScore = totalScore
+	t.Normalization.Sets = append(t.Normalization.Sets, NormalizedSet{
+		SupportingTools: slices.Clone(before.SupportingTools),
+		ScoreBefore:     before.Score,
+		ScoreAfter:      after.Score,
+	})
+}
+
+func (t *MergeTrace) setFileIdentityRule(index int, rule FileIdentityRule, s FeatureSet) {
+	if t == nil {
+		return
+	}
+	t.FileIdentityRule = &FiredFileIdentityRule{
+		Index:           index,
+		Line:            rule.line,
+		SupportingTools: slices.Clone(s.SupportingTools),
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	CHECKSUM_MD5    = "md5"
+	CHECKSUM_SHA1   = "sha1"
+	CHECKSUM_SHA256 = "sha256"
+	CHECKSUM_SHA512 = "sha512"
+)
+
+// FILE_SIZE_FEATURE is the key of the file size in bytes, which Borg
+// determines itself.
+const FILE_SIZE_FEATURE = "file:size"
+
+// CHECKSUM_ALGORITHMS contains all supported hash algorithms in the order they
+// are reported.
+var CHECKSUM_ALGORITHMS = []string{
+	CHECKSUM_MD5,
+	CHECKSUM_SHA1,
+	CHECKSUM_SHA256,
+	CHECKSUM_SHA512,
+}
+
+var (
+	ErrUnknownChecksumAlgorithm = errors.New("unknown checksum algorithm")
+	ErrConflictingChecksums     = errors.New("conflicting digests")
+)
+
+var CHECKSUM_LABELS = map[string]string{
+	CHECKSUM_MD5:    "MD5-Prüfsumme",
+	CHECKSUM_SHA1:   "SHA-1-Prüfsumme",
+	CHECKSUM_SHA256: "SHA-256-Prüfsumme",
+	CHECKSUM_SHA512: "SHA-512-Prüfsumme",
+}
+
+// Checksums maps the names of hash algorithms to hexadecimal digests of a file.
+type Checksums map[string]string
+
+// Features returns the checksums as file:checksum:* features.
+func (c Checksums) Features() map[string]ToolFeatureValue {
+	features := make(map[string]ToolFeatureValue)
+	for _, algorithm := range CHECKSUM_ALGORITHMS {
+		digest, ok := c[algorithm]
+		if !ok {
+			continue
+		}
+		label := CHECKSUM_LABELS[algorithm]
+		features["file:checksum:"+algorithm] = ToolFeatureValue{
+			Value: digest,
+			Label: &label,
+		}
+	}
+	return features
+}
+
+// NormalizeChecksums maps digests given by a client to the supported hash
+// algorithms. The names of the algorithms are compared case-insensitive and
+// without separators, so that "SHA-256" and "SHA256" name sha256 as well.
+// Unknown algorithms are rejected, since their digests couldn't be verified.
+func NormalizeChecksums(digests map[string]string) (Checksums, error) {
+	checksums := make(Checksums)
+	for name, digest := range digests {
+		digest = strings.TrimSpace(digest)
+		if digest == "" {
+			continue
+		}
+		algorithm := normalizeChecksumAlgorithm(name)
+		if !slices.Contains(CHECKSUM_ALGORITHMS, algorithm) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownChecksumAlgorithm, name)
+		}
+		if other, ok := checksums[algorithm]; ok && !strings.EqualFold(other, digest) {
+			return nil, fmt.Errorf("%w for checksum algorithm %s", ErrConflictingChecksums, algorithm)
+		}
+		checksums[algorithm] = digest
+	}
+	return checksums, nil
+}
+
+var checksumSeparators = strings.NewReplacer("-", "", "_", "", " ", "")
+
+func normalizeChecksumAlgorithm(name string) string {
+	return checksumSeparators.Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// Verify compares the checksums with expected digests, which must be
+// normalized with NormalizeChecksums. It returns the algorithms whose digests
+// don't match. Digests are compared case-insensitive.
+func (c Checksums) Verify(expected Checksums) []string {
+	var mismatches []string
+	for _, algorithm := range CHECKSUM_ALGORITHMS {
+		expectedDigest, ok := expected[algorithm]
+		if !ok {
+			continue
+		}
+		if !strings.EqualFold(strings.TrimSpace(expectedDigest), c[algorithm]) {
+			mismatches = append(mismatches, algorithm)
+		}
+	}
+	return mismatches
+}
+
+type multiHash map[string]hash.Hash
+
+func newMultiHash() multiHash {
+	return multiHash{
+		CHECKSUM_MD5:    md5.New(),
+		CHECKSUM_SHA1:   sha1.New(),
+		CHECKSUM_SHA256: sha256.New(),
+		CHECKSUM_SHA512: sha512.New(),
+	}
+}
+
+func (m multiHash) writer() io.Writer {
+	var writers []io.Writer
+	for _, algorithm := range CHECKSUM_ALGORITHMS {
+		writers = append(writers, m[algorithm])
+	}
+	return io.MultiWriter(writers...)
+}
+
+func (m multiHash) checksums() Checksums {
+	checksums := make(Checksums)
+	for algorithm, h := range m {
+		checksums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return checksums
+}
+
+// SaveFile writes the content of r to path. It returns the checksums of the
+// content, which are computed in the same pass.
+func SaveFile(r io.Reader, path string) (Checksums, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hashes := newMultiHash()
+	_, err = io.Copy(io.MultiWriter(file, hashes.writer()), r)
+	if err != nil {
+		return nil, err
+	}
+	return hashes.checksums(), nil
+}
+
+// ChecksumFile computes the checksums of a file.
+func ChecksumFile(path string) (Checksums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hashes := newMultiHash()
+	_, err = io.Copy(hashes.writer(), file)
+	if err != nil {
+		return nil, err
+	}
+	return hashes.checksums(), nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeChecksums(t *testing.T) {
+	tests := []struct {
+		name    string
+		digests map[string]string
+		want    Checksums
+		wantErr error
+	}{
+		{
+			name: "spellings",
+			digests: map[string]string{
+				"MD5":     "a",
+				"SHA-1":   "b",
+				"SHA256":  "c",
+				"sha_512": " d ",
+			},
+			want: Checksums{
+				CHECKSUM_MD5:    "a",
+				CHECKSUM_SHA1:   "b",
+				CHECKSUM_SHA256: "c",
+				CHECKSUM_SHA512: "d",
+			},
+		},
+		{
+			name:    "empty digest",
+			digests: map[string]string{"sha256": ""},
+			want:    Checksums{},
+		},
+		{
+			name:    "same digest twice",
+			digests: map[string]string{"sha256": "ABC", "SHA-256": "abc"},
+			want:    Checksums{CHECKSUM_SHA256: "abc"},
+		},
+		{
+			name:    "unknown algorithm",
+			digests: map[string]string{"sha3-256": "a"},
+			wantErr: ErrUnknownChecksumAlgorithm,
+		},
+		{
+			name:    "conflicting digests",
+			digests: map[string]string{"sha256": "a", "SHA-256": "b"},
+			wantErr: ErrConflictingChecksums,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NormalizeChecksums(test.digests)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got %v with error %v, want %v", got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.EqualFunc(got, test.want, strings.EqualFold) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HEALTH_CHECK_TIMEOUT limits the requests of a tool during a health check.
+const HEALTH_CHECK_TIMEOUT = 5 * time.Second
+
+// ToolHealth is the state of the service of a tool.
+type ToolHealth struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	Required bool   `json:"required"`
+	// Reachable means that the base endpoint of the tool service responded.
+	Reachable   bool  `json:"reachable"`
+	LatencyInMs int64 `json:"latencyInMs"`
+	// ToolVersion is empty if the tool service doesn't report its version.
+	ToolVersion string `json:"toolVersion"`
+	// Error is the reason why the tool service is unreachable.
+	Error *string `json:"error"`
+	// Circuit is the state of the circuit breaker of the tool.
+	Circuit string `json:"circuit"`
+	// LastError is the error of the last failed request of the tool, either
+	// during an analysis or a health check.
+	LastError   *string    `json:"lastError"`
+	LastErrorAt *time.Time `json:"lastErrorAt"`
+}
+
+// IsAvailable reports whether the tool can be used for analyses.
+func (h *ToolHealth) IsAvailable() bool {
+	return h.Reachable && h.Circuit != CIRCUIT_OPEN
+}
+
+// CheckToolHealth requests the base endpoints of all enabled tools
+// concurrently. The results are in the order of the server configuration.
+func CheckToolHealth(ctx context.Context, config *ServerConfig) []ToolHealth {
+	var responseChannels []chan ToolHealth
+	for _, toolConfig := range config.Tools {
+		if !toolConfig.Enabled {
+			continue
+		}
+		rc := make(chan ToolHealth)
+		responseChannels = append(responseChannels, rc)
+		go func() {
+			rc <- checkToolHealth(ctx, toolConfig)
+		}()
+	}
+	health := make([]ToolHealth, 0, len(responseChannels))
+	for _, rc := range responseChannels {
+		health = append(health, <-rc)
+	}
+	return health
+}
+
+func checkToolHealth(ctx context.Context, toolConfig ToolConfig) ToolHealth {
+	health := ToolHealth{
+		Id:       toolConfig.Id,
+		Title:    toolConfig.Title,
+		Required: toolConfig.Required,
+	}
+	baseEndpoint, err := getBaseEndpoint(toolConfig.Endpoint)
+	if err == nil {
+		start := time.Now()
+		err = requestToolService(ctx, baseEndpoint, nil)
+		health.LatencyInMs = time.Since(start).Milliseconds()
+	}
+	if err != nil {
+		errorMessage := err.Error()
+		health.Error = &errorMessage
+		breakers.recordError(toolConfig.Id, errorMessage)
+	} else {
+		health.Reachable = true
+		version, err := getToolServiceVersion(ctx, baseEndpoint)
+		if err == nil {
+			health.ToolVersion = version.ToolVersion
+		}
+	}
+	health.Circuit, health.LastError, health.LastErrorAt = breakers.getState(toolConfig.Id)
+	return health
+}
+
+// toolServiceVersion is the response of the version endpoint of a tool
+// service.
+type toolServiceVersion struct {
+	ToolVersion string `json:"toolVersion"`
+	// SignatureVersion names the signature files of identification tools.
+	SignatureVersion string `json:"signatureVersion"`
+}
+
+// getToolServiceVersion requests the versions reported by a tool service.
+// Older tool services don't provide their version.
+func getToolServiceVersion(ctx context.Context, baseEndpoint string) (toolServiceVersion, error) {
+	var version toolServiceVersion
+	err := requestToolService(ctx, baseEndpoint+"/version", &version)
+	return version, err
+}
+
+// getBaseEndpoint returns the endpoint of a tool without its path, which is
+// the endpoint every tool service responds to while it's running.
+func getBaseEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u.Path = ""
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// requestToolService requests an endpoint of a tool service and decodes the
+// JSON response into result, if given.
+func requestToolService(ctx context.Context, endpoint string, result any) error {
+	requestCtx, cancel := context.WithTimeout(ctx, HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(requestCtx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("tool service responded with status %d", response.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
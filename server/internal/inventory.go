@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+)
+
+// ToolInfo describes a configured tool and the versions reported by its
+// service, so that analysis results can be reproduced and explained.
+type ToolInfo struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	Enabled  bool   `json:"enabled"`
+	Required bool   `json:"required"`
+	// Identification means that the tool runs for every file, as opposed to
+	// tools that are triggered by the results of other tools.
+	Identification bool      `json:"identification"`
+	Triggers       []Trigger `json:"triggers"`
+	// Features are the declared features with their merge conditions and
+	// merge order.
+	Features []FeatureConfig `json:"features"`
+	Weight   Weight          `json:"weight"`
+	Fallback string          `json:"fallback"`
+	Timeout  string          `json:"timeout"`
+	Retries  int             `json:"retries"`
+	Backoff  string          `json:"backoff"`
+	// ValidationProfile is the profile or module checked by a validator.
+	ValidationProfile string `json:"validationProfile"`
+	// ToolVersion and SignatureVersion are fetched from the tool service. They
+	// are empty for disabled tools, unless the tool is the fallback of an
+	// enabled tool.
+	ToolVersion      string `json:"toolVersion"`
+	SignatureVersion string `json:"signatureVersion"`
+	// Error is the reason why the versions couldn't be fetched.
+	Error *string `json:"error"`
+}
+
+// GetToolInventory describes all configured tools in the order of the server
+// configuration. The versions of the enabled tools and their fallbacks are
+// requested concurrently.
+func GetToolInventory(ctx context.Context, config *ServerConfig) []ToolInfo {
+	responseChannels := make([]chan ToolInfo, len(config.Tools))
+	for i, toolConfig := range config.Tools {
+		rc := make(chan ToolInfo)
+		responseChannels[i] = rc
+		go func() {
+			rc <- getToolInfo(ctx, toolConfig, config.IsFallback(toolConfig.Id))
+		}()
+	}
+	inventory := make([]ToolInfo, 0, len(config.Tools))
+	for _, rc := range responseChannels {
+		inventory = append(inventory, <-rc)
+	}
+	return inventory
+}
+
+func getToolInfo(ctx context.Context, toolConfig ToolConfig, isFallback bool) ToolInfo {
+	info := ToolInfo{
+		Id:                toolConfig.Id,
+		Title:             toolConfig.Title,
+		Enabled:           toolConfig.Enabled,
+		Required:          toolConfig.Required,
+		Identification:    len(toolConfig.Triggers) == 0,
+		Triggers:          make([]Trigger, 0, len(toolConfig.Triggers)),
+		Features:          toolConfig.FeatureSet.Features,
+		Weight:            toolConfig.FeatureSet.Weight,
+		Fallback:          toolConfig.Fallback,
+		Timeout:           toolConfig.getTimeout().String(),
+		Retries:           toolConfig.Retries,
+		Backoff:           toolConfig.Backoff.String(),
+		ValidationProfile: toolConfig.ValidationProfile,
+	}
+	for _, t := range toolConfig.Triggers {
+		// the inventory shows the effective mode
+		if t.Mode == "" {
+			t.Mode = TRIGGER_MODE_TOOL_RESULTS
+		}
+		info.Triggers = append(info.Triggers, t)
+	}
+	if !toolConfig.Enabled && !isFallback {
+		return info
+	}
+	baseEndpoint, err := getBaseEndpoint(toolConfig.Endpoint)
+	if err == nil {
+		var version toolServiceVersion
+		version, err = getToolServiceVersion(ctx, baseEndpoint)
+		info.ToolVersion = version.ToolVersion
+		info.SignatureVersion = version.SignatureVersion
+	}
+	if err != nil {
+		errorMessage := err.Error()
+		info.Error = &errorMessage
+	}
+	return info
+}
@@ -1,15 +1,19 @@
 package internal
 
 import (
-	"fmt"
+	"cmp"
+	"log"
+	"maps"
 	"slices"
-	"sort"
 )
 
 type FeatureSet struct {
 	Features        map[string]MergeFeatureValue `json:"features"`
 	SupportingTools []string                     `json:"supportingTools"`
 	Score           float64                      `json:"score"`
+	// Conflicts is the number of features for which the supporting tools
+	// provided different values.
+	Conflicts int `json:"conflicts"`
 }
 
 type MergeFeatureValue struct {
@@ -17,53 +21,81 @@ type MergeFeatureValue struct {
 	Label           *string     `json:"label"`
 	MergeOrder      uint        `json:"-"`
 	SupportingTools []string    `json:"supportingTools"`
+	// Alternatives are the values of the feature that lost the merge, in the
+	// order of their precedence.
+	Alternatives []AlternativeFeatureValue `json:"alternatives,omitempty"`
 }
 
-func (s *FeatureSet) FulFillesAny(fileIdentityRules []FileIdentityRule) bool {
-	for _, rule := range fileIdentityRules {
+// AlternativeFeatureValue is a value of a feature that some tools of a set
+// provided, but that wasn't chosen by the merge.
+type AlternativeFeatureValue struct {
+	Value           interface{} `json:"value"`
+	Label           *string     `json:"label"`
+	SupportingTools []string    `json:"supportingTools"`
+}
+
+// getFulfilledRule returns the index of the first file identity rule the set
+// fulfills.
+func (s *FeatureSet) getFulfilledRule(fileIdentityRules []FileIdentityRule) (int, bool) {
+	for i, rule := range fileIdentityRules {
 		if s.FulFilles(rule) {
-			return true
+			return i, true
 		}
 	}
-	return false
+	return 0, false
 }
 
 func (s *FeatureSet) FulFilles(fileIdentityRule FileIdentityRule) bool {
+	lookup := lookupFeatureSet(*s, nil)
 	for _, condition := range fileIdentityRule.Conditions {
-		v, ok := s.Features[condition.Feature]
-		if !ok || !condition.IsFulfilled(v.Value) {
+		if !condition.IsFulfilled(lookup, nil) {
 			return false
 		}
 	}
 	return true
 }
 
-type ByScore []FeatureSet
+// toolOrder maps the id of every configured tool to its position in the
+// server configuration. The order of the tools in the configuration decides
+// the merge whenever the scores and values are tied.
+type toolOrder map[string]int
+
+func (c *ServerConfig) getToolOrder() toolOrder {
+	order := make(toolOrder, len(c.Tools))
+	for i, toolConfig := range c.Tools {
+		order[toolConfig.Id] = i
+	}
+	return order
+}
 
-func (a ByScore) Len() int           { return len(a) }
-func (a ByScore) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByScore) Less(i, j int) bool { return a[i].Score < a[j].Score }
+func (o toolOrder) compareTools(id1 string, id2 string) int {
+	return cmp.Compare(o[id1], o[id2])
+}
 
-// IsEqual compares two feature sets. Sets are considered as equal if the same tools support them.
-func (s1 *FeatureSet) IsEqual(s2 FeatureSet) bool {
-	if len(s1.SupportingTools) != len(s2.SupportingTools) {
-		return false
+// compareFeatureSets orders feature sets by descending score. Sets with equal
+// scores are ordered by descending number of supporting tools and then by
+// the position of their tools in the configuration.
+func (o toolOrder) compareFeatureSets(s1 FeatureSet, s2 FeatureSet) int {
+	if s1.Score != s2.Score {
+		return cmp.Compare(s2.Score, s1.Score)
 	}
-	t1 := s1.SupportingTools
-	t2 := s2.SupportingTools
-	slices.Sort(t1)
-	slices.Sort(t2)
-	for index, toolId := range t1 {
-		if toolId != t2[index] {
-			return false
-		}
+	if len(s1.SupportingTools) != len(s2.SupportingTools) {
+		return cmp.Compare(len(s2.SupportingTools), len(s1.SupportingTools))
 	}
-	return true
+	return slices.CompareFunc(s1.SupportingTools, s2.SupportingTools, o.compareTools)
+}
+
+// IsEqual compares two feature sets. Sets are considered as equal if the same tools support them.
+func (s1 *FeatureSet) IsEqual(s2 FeatureSet) bool {
+	t1 := slices.Sorted(slices.Values(s1.SupportingTools))
+	t2 := slices.Sorted(slices.Values(s2.SupportingTools))
+	return slices.Equal(t1, t2)
 }
 
 // filterDuplicateSets removes duplicates of sets depending on the supporting tools.
-// If duplicates are found the one with the higher score remains.
-func filterDuplicateSets(sets []FeatureSet) []FeatureSet {
+// If duplicates are found the one with the higher score remains. On equal
+// scores the set that comes first remains.
+func filterDuplicateSets(sets []FeatureSet, trace *MergeTrace) []FeatureSet {
 	var filteredSets []FeatureSet
 	for _, s := range sets {
 		setExistsAlready := false
@@ -72,6 +104,9 @@ func filterDuplicateSets(sets []FeatureSet) []FeatureSet {
 				setExistsAlready = true
 				if s.Score > fs.Score {
 					filteredSets[index] = s
+					trace.addDuplicate(fs, s)
+				} else {
+					trace.addDuplicate(s, fs)
 				}
 				break
 			}
@@ -83,7 +118,7 @@ func filterDuplicateSets(sets []FeatureSet) []FeatureSet {
 	return filteredSets
 }
 
-func normalizeSetScore(sets []FeatureSet) []FeatureSet {
+func normalizeSetScore(sets []FeatureSet, trace *MergeTrace) []FeatureSet {
 	var normalizedSets []FeatureSet
 	totalScore := 0.0
 	for _, s := range sets {
@@ -93,18 +128,22 @@ func normalizeSetScore(sets []FeatureSet) []FeatureSet {
 		return normalizedSets
 	}
 	for _, s := range sets {
+		original := s
 		normalizedScore := s.Score / totalScore
 		if normalizedScore < s.Score {
 			s.Score = normalizedScore
 		}
+		trace.addNormalization(totalScore, original, s)
 		normalizedSets = append(normalizedSets, s)
 	}
 	return normalizedSets
 }
 
-func applyFileIdentityRules(sets []FeatureSet) []FeatureSet {
+func applyFileIdentityRules(config *ServerConfig, sets []FeatureSet, trace *MergeTrace) []FeatureSet {
 	for i, s := range sets {
-		if s.FulFillesAny(serverConfig.FileIdentityRules) {
+		ruleIndex, ok := s.getFulfilledRule(config.FileIdentityRules)
+		if ok {
+			trace.setFileIdentityRule(ruleIndex, config.FileIdentityRules[ruleIndex], s)
 			return setFileIdentity(sets, i)
 		}
 	}
@@ -128,34 +167,78 @@ type Merge struct {
 	toolConfigs      []ToolConfig
 	toolResults      []ToolResult
 	AccumulatedScore float64
+	// trace records the merge attempts, it is nil if no trace is requested.
+	trace *MergeTrace
+	order toolOrder
 }
 
 func (m *Merge) MergeIfPossible(tc2 ToolConfig, tr2 ToolResult) {
-	isMergeable, mergeModifier := m.IsMergeable(tc2, tr2)
+	attempt := MergeAttempt{
+		OriginToolId: tc2.Id,
+		ToolId:       tc2.Id,
+		Conditions:   make([]MergeConditionOutcome, 0),
+		ScoreBefore:  m.AccumulatedScore,
+	}
+	if len(m.toolConfigs) > 0 {
+		attempt.OriginToolId = m.toolConfigs[0].Id
+	}
+	isMergeable, mergeModifier := m.isMergeable(tc2, tr2, &attempt)
 	if isMergeable {
+		weight, weightSource := tc2.FeatureSet.Weight.getWeightWithSource(tr2)
 		if len(m.toolConfigs) == 0 {
-			m.AccumulatedScore = tc2.FeatureSet.Weight.GetWeight(tr2)
+			m.AccumulatedScore = weight
 		} else {
-			m.AccumulatedScore += mergeModifier * tc2.FeatureSet.Weight.GetWeight(tr2)
+			m.AccumulatedScore += mergeModifier * weight
 		}
 		m.toolConfigs = append(m.toolConfigs, tc2)
 		m.toolResults = append(m.toolResults, tr2)
+		attempt.Weight = weight
+		attempt.WeightSource = weightSource
 	}
+	attempt.Merged = isMergeable
+	attempt.MergeModifier = mergeModifier
+	attempt.ScoreAfter = m.AccumulatedScore
+	m.trace.addAttempt(attempt)
 }
 
 func (m *Merge) IsMergeable(tc2 ToolConfig, tr2 ToolResult) (isMergeable bool, mergeModifier float64) {
+	return m.isMergeable(tc2, tr2, &MergeAttempt{})
+}
+
+// isMergeable is IsMergeable, which additionally records the outcomes of the
+// merge conditions in attempt.
+func (m *Merge) isMergeable(
+	tc2 ToolConfig,
+	tr2 ToolResult,
+	attempt *MergeAttempt,
+) (isMergeable bool, mergeModifier float64) {
 	if tr2.Error != nil {
+		attempt.SkipReason = MERGE_SKIPPED_TOOL_ERROR
 		return
 	}
 	mergedResults := m.GetMergedToolResults()
 	// check if all conditions of the new set are met
-	isMergeable, mergeModifier = tc2.FeatureSet.AreMergeable(mergedResults.Features, tr2.Features)
+	isMergeable, mergeModifier, outcomes := tc2.FeatureSet.checkMergeConditions(
+		mergedResults.Features,
+		tr2.Features,
+	)
+	attempt.addConditions(tc2.Id, outcomes)
 	if !isMergeable {
+		attempt.SkipReason = MERGE_SKIPPED_NO_STRONG_LINK
+		if slices.ContainsFunc(outcomes, isRejected) {
+			attempt.SkipReason = MERGE_SKIPPED_REJECTED
+		}
 		return
 	}
 	// check if all conditions of already merged sets are met
 	for _, tc := range m.toolConfigs {
-		subsetMergeable, _ := tc.FeatureSet.AreMergeable(mergedResults.Features, tr2.Features)
+		subsetMergeable, _, outcomes := tc.FeatureSet.checkMergeConditions(
+			mergedResults.Features,
+			tr2.Features,
+		)
+		attempt.addConditions(tc.Id, outcomes)
+		// the conditions of already merged tools don't prevent the merge, the
+		// remaining tools aren't checked once a condition isn't met
 		if !subsetMergeable {
 			return
 		}
@@ -163,85 +246,162 @@ func (m *Merge) IsMergeable(tc2 ToolConfig, tr2 ToolResult) (isMergeable bool, m
 	return
 }
 
+func (a *MergeAttempt) addConditions(configToolId string, outcomes []MergeConditionOutcome) {
+	for _, outcome := range outcomes {
+		outcome.ConfigToolId = configToolId
+		a.Conditions = append(a.Conditions, outcome)
+	}
+}
+
+func isRejected(outcome MergeConditionOutcome) bool {
+	return outcome.Outcome == MERGE_LINK_REJECTED
+}
+
 func (m *Merge) GetMergedToolResults() FeatureSet {
 	features := make(map[string]MergeFeatureValue)
 	featureValues := make(map[string][]MergeFeatureValue)
-	// gather all existing feature values
-	for _, tr := range m.toolResults {
-		tc := getToolConfig(tr.Id)
-		for k, v := range tr.Features {
-			featureValue := MergeFeatureValue{
-				Value:           v.Value,
-				Label:           v.Label,
-				SupportingTools: []string{tc.Id},
-			}
+	// gather all existing feature values in the order of the configuration,
+	// so that the result doesn't depend on the tool the set started with
+	order := make([]int, len(m.toolConfigs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(i, j int) int {
+		return m.order.compareTools(m.toolConfigs[i].Id, m.toolConfigs[j].Id)
+	})
+	for _, i := range order {
+		tc := m.toolConfigs[i]
+		for k, v := range m.toolResults[i].Features {
+			var mergeOrder uint
 			featureConfig, ok := tc.FeatureSet.GetFeatureConfig(k)
 			if ok {
-				featureValue.MergeOrder = featureConfig.MergeOrder
+				mergeOrder = featureConfig.MergeOrder
 			}
-			featureValues[k] = append(
-				featureValues[k],
-				featureValue,
-			)
+			featureValues[k] = addFeatureValue(featureValues[k], tc.Id, v, mergeOrder)
 		}
 	}
-	// merge the feature values
+	// merge the feature values, the values that lost the merge are kept as
+	// alternatives
+	conflicts := 0
 	for key, values := range featureValues {
-		for i, v := range values {
-			if i == 0 {
-				features[key] = v
-			} else {
-				if features[key].Value == v.Value {
-					tools := append(features[key].SupportingTools, v.SupportingTools...)
-					mergeOrder := features[key].MergeOrder
-					label := features[key].Label
-					if v.MergeOrder > mergeOrder {
-						mergeOrder = v.MergeOrder
-						label = v.Label
-					}
-					features[key] = MergeFeatureValue{
-						Value:           v.Value,
-						Label:           label,
-						SupportingTools: tools,
-						MergeOrder:      mergeOrder,
-					}
-				} else if v.MergeOrder > features[key].MergeOrder {
-					features[key] = v
-				}
-			}
+		slices.SortFunc(values, m.order.compareFeatureValues)
+		merged := values[0]
+		for _, v := range values[1:] {
+			merged.Alternatives = append(merged.Alternatives, AlternativeFeatureValue{
+				Value:           v.Value,
+				Label:           v.Label,
+				SupportingTools: v.SupportingTools,
+			})
+		}
+		if len(merged.Alternatives) > 0 {
+			conflicts++
 		}
+		features[key] = merged
 	}
 	supportingTools := make([]string, 0)
-	for _, tc := range m.toolConfigs {
-		supportingTools = append(supportingTools, tc.Id)
+	for _, i := range order {
+		supportingTools = append(supportingTools, m.toolConfigs[i].Id)
 	}
 	return FeatureSet{
 		Features:        features,
 		SupportingTools: supportingTools,
 		Score:           m.AccumulatedScore,
+		Conflicts:       conflicts,
+	}
+}
+
+// addFeatureValue adds the value a tool provided for a feature. Equal values
+// of different tools are combined and the label with the highest merge order
+// is kept.
+func addFeatureValue(
+	values []MergeFeatureValue,
+	toolId string,
+	v ToolFeatureValue,
+	mergeOrder uint,
+) []MergeFeatureValue {
+	for i, value := range values {
+		if value.Value == v.Value {
+			values[i].SupportingTools = append(value.SupportingTools, toolId)
+			if mergeOrder > value.MergeOrder {
+				values[i].Label = v.Label
+				values[i].MergeOrder = mergeOrder
+			}
+			return values
+		}
+	}
+	return append(values, MergeFeatureValue{
+		Value:           v.Value,
+		Label:           v.Label,
+		MergeOrder:      mergeOrder,
+		SupportingTools: []string{toolId},
+	})
+}
+
+// compareFeatureValues orders the values of a feature by precedence: the
+// highest merge order wins, then the value supported by the most tools and
+// finally the value of the tool configured first. The supporting tools must
+// be in the order of the configuration.
+func (o toolOrder) compareFeatureValues(v1 MergeFeatureValue, v2 MergeFeatureValue) int {
+	if v1.MergeOrder != v2.MergeOrder {
+		return cmp.Compare(v2.MergeOrder, v1.MergeOrder)
+	}
+	if len(v1.SupportingTools) != len(v2.SupportingTools) {
+		return cmp.Compare(len(v2.SupportingTools), len(v1.SupportingTools))
 	}
+	return o.compareTools(v1.SupportingTools[0], v2.SupportingTools[0])
 }
 
-func MergeFeatureSets(toolResults map[string]ToolResult) []FeatureSet {
+func MergeFeatureSets(config *ServerConfig, toolResults map[string]ToolResult) []FeatureSet {
+	return mergeFeatureSets(config, toolResults, nil)
+}
+
+// ExplainMergeFeatureSets merges the feature sets like MergeFeatureSets and
+// records every decision of the merge.
+func ExplainMergeFeatureSets(
+	config *ServerConfig,
+	toolResults map[string]ToolResult,
+) ([]FeatureSet, *MergeTrace) {
+	trace := &MergeTrace{
+		Attempts:   make([]MergeAttempt, 0),
+		Duplicates: make([]DuplicateSet, 0),
+		Normalization: ScoreNormalization{
+			Sets: make([]NormalizedSet, 0),
+		},
+	}
+	return mergeFeatureSets(config, toolResults, trace), trace
+}
+
+func mergeFeatureSets(
+	config *ServerConfig,
+	toolResults map[string]ToolResult,
+	trace *MergeTrace,
+) []FeatureSet {
 	var mergedSets []FeatureSet
-	for toolId, tr1 := range toolResults {
+	order := config.getToolOrder()
+	for _, toolId := range slices.Sorted(maps.Keys(toolResults)) {
+		if _, ok := order[toolId]; !ok {
+			log.Printf("no tool configuration for id: %s", toolId)
+		}
+	}
+	// the tools are merged in the order of the server configuration,
+	// independent of the order of the results
+	for _, tc1 := range config.Tools {
+		tr1, ok := toolResults[tc1.Id]
 		// don't merge tool results without any extracted features
-		if len(tr1.Features) == 0 {
+		if !ok || len(tr1.Features) == 0 {
 			continue
 		}
 		// don't merge results with errors
 		if tr1.Error != nil {
 			continue
 		}
-		var m Merge
-		tc1 := getToolConfig(toolId)
+		m := Merge{trace: trace, order: order}
 		m.MergeIfPossible(tc1, tr1)
-		for _, tc2 := range serverConfig.Tools {
+		for _, tc2 := range config.Tools {
 			// don't merge feature set with itself
-			if toolId == tc2.Id {
+			if tc1.Id == tc2.Id {
 				continue
 			}
-			// check if a result exists for tool configuration
 			tr2, ok := toolResults[tc2.Id]
 			if !ok {
 				continue
@@ -250,18 +410,18 @@ func MergeFeatureSets(toolResults map[string]ToolResult) []FeatureSet {
 		}
 		mergedSets = append(mergedSets, m.GetMergedToolResults())
 	}
-	revisedSets := filterDuplicateSets(mergedSets)
-	revisedSets = normalizeSetScore(revisedSets)
-	revisedSets = applyFileIdentityRules(revisedSets)
-	sort.Sort(sort.Reverse(ByScore(revisedSets)))
+	revisedSets := filterDuplicateSets(mergedSets, trace)
+	revisedSets = normalizeSetScore(revisedSets, trace)
+	revisedSets = applyFileIdentityRules(config, revisedSets, trace)
+	slices.SortStableFunc(revisedSets, order.compareFeatureSets)
 	return revisedSets
 }
 
-func getToolConfig(id string) ToolConfig {
-	for _, config := range serverConfig.Tools {
-		if config.Id == id {
-			return config
+func (c *ServerConfig) getToolConfig(id string) (ToolConfig, bool) {
+	for _, toolConfig := range c.Tools {
+		if toolConfig.Id == id {
+			return toolConfig, true
 		}
 	}
-	panic(fmt.Sprintf("faulty configuration: no tool configuration for id: %s", id))
+	return ToolConfig{}, false
 }
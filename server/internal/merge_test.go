@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"lath/borg/toolapi"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// REPEATED_RUNS is the number of repeated merges per test case. The iteration
+// order of the tool results map differs between the runs.
+const REPEATED_RUNS = 50
+
+func newTestToolConfig(id string, weight float64, features ...FeatureConfig) ToolConfig {
+	return ToolConfig{
+		Id:      id,
+		Enabled: true,
+		FeatureSet: FeatureSetConfig{
+			Features: features,
+			Weight:   Weight{Default: weight},
+		},
+	}
+}
+
+func newTestToolResult(id string, features map[string]interface{}) ToolResult {
+	result := ToolResult{
+		Id:       id,
+		Title:    id,
+		Features: make(map[string]ToolFeatureValue),
+	}
+	for key, value := range features {
+		result.Features[key] = ToolFeatureValue{Value: value}
+	}
+	return result
+}
+
+// linkedFeature is a feature with a merge condition, which links the results
+// of tools with equal values.
+func linkedFeature(key string, mergeOrder uint) FeatureConfig {
+	return FeatureConfig{
+		Key:            key,
+		MergeOrder:     mergeOrder,
+		MergeCondition: &MergeCondition{ExactMatch: true},
+	}
+}
+
+func TestMergeFeatureSetsIsDeterministic(t *testing.T) {
+	failed := newTestToolResult("failed", map[string]interface{}{
+		"format:mimeType": "application/pdf",
+	})
+	failed.Error = &ToolError{Code: toolapi.ERROR_CODE_TOOL_FAILED, Message: "tool failed"}
+	tests := []struct {
+		name        string
+		tools       []ToolConfig
+		results     []ToolResult
+		identity    []FileIdentityRule
+		wantTopSet  []string
+		wantName    string
+		wantSetSize int
+	}{
+		{
+			name: "tie in merge order",
+			tools: []ToolConfig{
+				newTestToolConfig("a", 0.75, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:name"}),
+				newTestToolConfig("b", 0.75, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:name"}),
+				newTestToolConfig("c", 0.75, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:name"}),
+			},
+			results: []ToolResult{
+				newTestToolResult("a", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:name":     "Acrobat PDF",
+				}),
+				newTestToolResult("b", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:name":     "Portable Document Format",
+				}),
+				newTestToolResult("c", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:name":     "Portable Document Format",
+				}),
+			},
+			wantTopSet:  []string{"a", "b", "c"},
+			wantName:    "Portable Document Format",
+			wantSetSize: 1,
+		},
+		{
+			name: "tie without majority",
+			tools: []ToolConfig{
+				newTestToolConfig("droid", 0.75, linkedFeature("format:puid", 0), FeatureConfig{Key: "format:name"}),
+				newTestToolConfig("siegfried", 0.75, linkedFeature("format:puid", 0), FeatureConfig{Key: "format:name"}),
+			},
+			results: []ToolResult{
+				newTestToolResult("droid", map[string]interface{}{
+					"format:puid": "fmt/19",
+					"format:name": "Acrobat PDF 1.5",
+				}),
+				newTestToolResult("siegfried", map[string]interface{}{
+					"format:puid": "fmt/19",
+					"format:name": "Acrobat PDF 1.5 - Portable Document Format",
+				}),
+			},
+			wantTopSet:  []string{"droid", "siegfried"},
+			wantName:    "Acrobat PDF 1.5",
+			wantSetSize: 1,
+		},
+		{
+			name: "equal set scores",
+			tools: []ToolConfig{
+				newTestToolConfig("a", 0.75, linkedFeature("format:mimeType", 0)),
+				newTestToolConfig("b", 0.75, linkedFeature("format:mimeType", 0)),
+				newTestToolConfig("c", 0.75, linkedFeature("format:mimeType", 0)),
+			},
+			results: []ToolResult{
+				newTestToolResult("c", map[string]interface{}{"format:mimeType": "image/png"}),
+				newTestToolResult("b", map[string]interface{}{"format:mimeType": "image/jpeg"}),
+				newTestToolResult("a", map[string]interface{}{"format:mimeType": "image/gif"}),
+			},
+			wantTopSet:  []string{"a"},
+			wantSetSize: 3,
+		},
+		{
+			name: "duplicate sets with merge order",
+			tools: []ToolConfig{
+				newTestToolConfig("a", 0.75, linkedFeature("format:mimeType", 1), FeatureConfig{Key: "format:version", MergeOrder: 2}),
+				newTestToolConfig("b", 1, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:version"}),
+				newTestToolConfig("c", 0.5, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:version"}),
+				newTestToolConfig("d", 0.4, linkedFeature("format:mimeType", 0)),
+			},
+			results: []ToolResult{
+				newTestToolResult("a", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:version":  "1.4",
+				}),
+				newTestToolResult("b", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:version":  "1.5",
+				}),
+				newTestToolResult("c", map[string]interface{}{
+					"format:mimeType": "application/pdf",
+					"format:version":  "1.5",
+				}),
+				newTestToolResult("d", map[string]interface{}{"format:mimeType": "video/mp4"}),
+				newTestToolResult("empty", nil),
+				failed,
+			},
+			wantTopSet:  []string{"a", "b", "c"},
+			wantSetSize: 2,
+		},
+		{
+			name: "file identity rule",
+			tools: []ToolConfig{
+				newTestToolConfig("a", 0.75, linkedFeature("format:mimeType", 0)),
+				newTestToolConfig("b", 0.75, linkedFeature("format:mimeType", 0)),
+			},
+			results: []ToolResult{
+				newTestToolResult("a", map[string]interface{}{"format:mimeType": "text/plain"}),
+				newTestToolResult("b", map[string]interface{}{"format:mimeType": "text/csv"}),
+			},
+			identity: []FileIdentityRule{{
+				Conditions: []FeatureCondition{{Feature: "format:mimeType", Value: "text/csv"}},
+			}},
+			wantTopSet:  []string{"b"},
+			wantSetSize: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &ServerConfig{
+				Tools:             test.tools,
+				FileIdentityRules: test.identity,
+			}
+			want, wantTrace := ExplainMergeFeatureSets(config, getTestToolResults(test.results))
+			if len(want) != test.wantSetSize {
+				t.Fatalf("got %d sets, want %d", len(want), test.wantSetSize)
+			}
+			if !reflect.DeepEqual(want[0].SupportingTools, test.wantTopSet) {
+				t.Errorf("got top set %v, want %v", want[0].SupportingTools, test.wantTopSet)
+			}
+			if test.wantName != "" && want[0].Features["format:name"].Value != test.wantName {
+				t.Errorf(
+					"got name %v, want %v",
+					want[0].Features["format:name"].Value,
+					test.wantName,
+				)
+			}
+			for range REPEATED_RUNS {
+				got, gotTrace := ExplainMergeFeatureSets(config, getTestToolResults(test.results))
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("repeated merge differs:\ngot  %+v\nwant %+v", got, want)
+				}
+				if !reflect.DeepEqual(gotTrace, wantTrace) {
+					t.Fatalf("repeated trace differs:\ngot  %+v\nwant %+v", gotTrace, wantTrace)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeFeatureSetsFollowsConfigOrder(t *testing.T) {
+	tools := []ToolConfig{
+		newTestToolConfig("droid", 0.75, linkedFeature("format:puid", 0), FeatureConfig{Key: "format:name"}),
+		newTestToolConfig("siegfried", 0.75, linkedFeature("format:puid", 0), FeatureConfig{Key: "format:name"}),
+		newTestToolConfig("tika", 0.75, linkedFeature("format:puid", 0)),
+	}
+	reversed := slices.Clone(tools)
+	slices.Reverse(reversed)
+	results := getTestToolResults([]ToolResult{
+		newTestToolResult("droid", map[string]interface{}{
+			"format:puid": "fmt/19",
+			"format:name": "Acrobat PDF 1.5",
+		}),
+		newTestToolResult("siegfried", map[string]interface{}{
+			"format:puid": "fmt/19",
+			"format:name": "Acrobat PDF 1.5 - Portable Document Format",
+		}),
+		newTestToolResult("tika", map[string]interface{}{"format:puid": "fmt/20"}),
+	})
+	tests := []struct {
+		name        string
+		tools       []ToolConfig
+		wantTopSet  []string
+		wantName    string
+		wantLastSet []string
+	}{
+		{
+			name:        "configuration order",
+			tools:       tools,
+			wantTopSet:  []string{"droid", "siegfried"},
+			wantName:    "Acrobat PDF 1.5",
+			wantLastSet: []string{"tika"},
+		},
+		{
+			name:        "reversed configuration order",
+			tools:       reversed,
+			wantTopSet:  []string{"siegfried", "droid"},
+			wantName:    "Acrobat PDF 1.5 - Portable Document Format",
+			wantLastSet: []string{"tika"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sets := MergeFeatureSets(&ServerConfig{Tools: test.tools}, results)
+			if len(sets) != 2 {
+				t.Fatalf("got %d sets, want 2", len(sets))
+			}
+			if !reflect.DeepEqual(sets[0].SupportingTools, test.wantTopSet) {
+				t.Errorf("got top set %v, want %v", sets[0].SupportingTools, test.wantTopSet)
+			}
+			if sets[0].Features["format:name"].Value != test.wantName {
+				t.Errorf("got name %v, want %v", sets[0].Features["format:name"].Value, test.wantName)
+			}
+			if !reflect.DeepEqual(sets[1].SupportingTools, test.wantLastSet) {
+				t.Errorf("got last set %v, want %v", sets[1].SupportingTools, test.wantLastSet)
+			}
+		})
+	}
+}
+
+func TestMergeTraceRecordsConditionsOfMergedTools(t *testing.T) {
+	config := &ServerConfig{
+		Tools: []ToolConfig{
+			newTestToolConfig("a", 0.75, linkedFeature("format:mimeType", 0), linkedFeature("format:version", 0)),
+			newTestToolConfig("b", 0.75, linkedFeature("format:mimeType", 0), FeatureConfig{Key: "format:version"}),
+		},
+	}
+	results := getTestToolResults([]ToolResult{
+		newTestToolResult("a", map[string]interface{}{
+			"format:mimeType": "application/pdf",
+			"format:version":  "1.4",
+		}),
+		newTestToolResult("b", map[string]interface{}{
+			"format:mimeType": "application/pdf",
+			"format:version":  "1.5",
+		}),
+	})
+	sets := MergeFeatureSets(config, results)
+	explainedSets, trace := ExplainMergeFeatureSets(config, results)
+	// the trace doesn't change the merge
+	if !reflect.DeepEqual(explainedSets, sets) {
+		t.Fatalf("explained merge differs:\ngot  %+v\nwant %+v", explainedSets, sets)
+	}
+	// the rejected condition of the merged tool a doesn't prevent the merge
+	if !reflect.DeepEqual(sets[0].SupportingTools, []string{"a", "b"}) {
+		t.Fatalf("got top set %v, want [a b]", sets[0].SupportingTools)
+	}
+	for _, attempt := range trace.Attempts {
+		if attempt.OriginToolId != "a" || attempt.ToolId != "b" {
+			continue
+		}
+		if !attempt.Merged || attempt.SkipReason != "" {
+			t.Errorf("got merged %t with skip reason %q, want merged", attempt.Merged, attempt.SkipReason)
+		}
+		rejected := slices.ContainsFunc(attempt.Conditions, func(outcome MergeConditionOutcome) bool {
+			return outcome.ConfigToolId == "a" && isRejected(outcome)
+		})
+		if !rejected {
+			t.Errorf("got conditions %+v, want the rejected condition of tool a", attempt.Conditions)
+		}
+		return
+	}
+	t.Fatal("merge attempt of b into the set of a missing")
+}
+
+func getTestToolResults(results []ToolResult) map[string]ToolResult {
+	toolResults := make(map[string]ToolResult)
+	for _, result := range results {
+		toolResults[result.Id] = result
+	}
+	return toolResults
+}
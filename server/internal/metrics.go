@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ANALYSIS_STATUS_OK = "ok"
+	// ANALYSIS_STATUS_ERROR means that one or more tools failed.
+	ANALYSIS_STATUS_ERROR = "error"
+	// ANALYSIS_STATUS_CANCELED means that the client disconnected.
+	ANALYSIS_STATUS_CANCELED = "canceled"
+)
+
+// Kinds of tool errors counted by the metrics.
+const (
+	TOOL_ERROR_TIMEOUT     = "timeout"
+	TOOL_ERROR_UNREACHABLE = "unreachable"
+	// TOOL_ERROR_HTTP_STATUS means that the tool wrapper responded with an
+	// error status.
+	TOOL_ERROR_HTTP_STATUS = "httpStatus"
+	// TOOL_ERROR_PARSE means that the response of the tool wrapper couldn't be
+	// parsed.
+	TOOL_ERROR_PARSE = "parseFailure"
+	// TOOL_ERROR_TOOL means that the tool itself reported an error.
+	TOOL_ERROR_TOOL = "toolError"
+	// TOOL_ERROR_SKIPPED means that the tool was skipped by the circuit
+	// breaker.
+	TOOL_ERROR_SKIPPED  = "skipped"
+	TOOL_ERROR_CANCELED = "canceled"
+)
+
+// METRIC_LABEL_OTHER replaces PUIDs and MIME types that aren't used as label
+// values, so that unexpected tool output can't create arbitrary many series.
+const METRIC_LABEL_OTHER = "other"
+
+// MAX_METRIC_LABEL_VALUES limits the number of distinct PUIDs and MIME types
+// per metric. Further values are counted as METRIC_LABEL_OTHER.
+const MAX_METRIC_LABEL_VALUES = 500
+
+var (
+	PUID_PATTERN = regexp.MustCompile(`^(x-)?fmt/[0-9]+$`)
+	// MIME_TYPE_PATTERN accepts the registered top-level types only.
+	MIME_TYPE_PATTERN = regexp.MustCompile(
+		`^(application|audio|font|image|message|model|multipart|text|video)/[a-z0-9][a-z0-9!#$&^_.+-]*$`,
+	)
+)
+
+// DURATION_BUCKETS are the buckets of the duration histograms in seconds. They
+// cover fast identifications as well as long validations.
+var DURATION_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+var (
+	analysesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_analyses_total",
+		Help: "Number of analyzed files by status.",
+	}, []string{"status"})
+	analysisDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "borg_analysis_duration_seconds",
+		Help:    "Duration of the analyses from the start of the request to the merged result.",
+		Buckets: DURATION_BUCKETS,
+	})
+	toolResponseTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borg_tool_response_time_seconds",
+		Help:    "Response time of the tools, without cached results.",
+		Buckets: DURATION_BUCKETS,
+	}, []string{"tool"})
+	toolErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_tool_errors_total",
+		Help: "Number of failed tool requests by tool and kind of error.",
+	}, []string{"tool", "kind"})
+	toolTriggersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_tool_triggers_total",
+		Help: "Number of times a tool was triggered.",
+	}, []string{"tool"})
+	puidsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_identified_puids_total",
+		Help: "Number of analyzed files by identified PUID.",
+	}, []string{"puid"})
+	mimeTypesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_identified_mime_types_total",
+		Help: "Number of analyzed files by identified MIME type.",
+	}, []string{"mime_type"})
+	validityTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_validity_verdicts_total",
+		Help: "Number of analyzed files by validity verdict.",
+	}, []string{"verdict"})
+)
+
+var (
+	puidLabels     = newLabelValues(PUID_PATTERN)
+	mimeTypeLabels = newLabelValues(MIME_TYPE_PATTERN)
+)
+
+// RecordAnalysis updates the metrics with the result of an analysis.
+func RecordAnalysis(summary Summary, duration time.Duration, canceled bool) {
+	status := ANALYSIS_STATUS_OK
+	if canceled {
+		status = ANALYSIS_STATUS_CANCELED
+	} else if len(summary.Errors) > 0 {
+		status = ANALYSIS_STATUS_ERROR
+	}
+	analysesTotal.WithLabelValues(status).Inc()
+	analysisDuration.Observe(duration.Seconds())
+	if canceled {
+		return
+	}
+	if summary.PUID != nil {
+		puidsTotal.WithLabelValues(puidLabels.normalize(*summary.PUID)).Inc()
+	}
+	if summary.MimeType != nil {
+		mimeTypesTotal.WithLabelValues(mimeTypeLabels.normalize(*summary.MimeType)).Inc()
+	}
+	if summary.Valid {
+		validityTotal.WithLabelValues("valid").Inc()
+	}
+	if summary.Invalid {
+		validityTotal.WithLabelValues("invalid").Inc()
+	}
+}
+
+// recordToolResult updates the metrics with a requested tool result. The
+// error kind is empty if the tool succeeded.
+func recordToolResult(toolId string, responseTime time.Duration, errorKind string) {
+	if errorKind != TOOL_ERROR_CANCELED && errorKind != TOOL_ERROR_SKIPPED {
+		toolResponseTime.WithLabelValues(toolId).Observe(responseTime.Seconds())
+	}
+	if errorKind != "" {
+		toolErrorsTotal.WithLabelValues(toolId, errorKind).Inc()
+	}
+}
+
+func recordToolTriggered(toolId string) {
+	toolTriggersTotal.WithLabelValues(toolId).Inc()
+}
+
+// labelValues bounds the values of a label that is taken from tool output.
+type labelValues struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	values  map[string]bool
+}
+
+func newLabelValues(pattern *regexp.Regexp) *labelValues {
+	return &labelValues{pattern: pattern, values: make(map[string]bool)}
+}
+
+// normalize returns the label value for value. Parameters like a charset are
+// removed and the value is lowercased. Values that don't match the pattern or
+// exceed MAX_METRIC_LABEL_VALUES are replaced by METRIC_LABEL_OTHER.
+func (l *labelValues) normalize(value string) string {
+	value, _, _ = strings.Cut(value, ";")
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !l.pattern.MatchString(value) {
+		return METRIC_LABEL_OTHER
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.values[value] {
+		if len(l.values) >= MAX_METRIC_LABEL_VALUES {
+			return METRIC_LABEL_OTHER
+		}
+		l.values[value] = true
+	}
+	return value
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelValuesNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    *labelValues
+		value     string
+		wantLabel string
+	}{
+		{"puid", newLabelValues(PUID_PATTERN), "fmt/19", "fmt/19"},
+		{"extension puid", newLabelValues(PUID_PATTERN), "x-fmt/111", "x-fmt/111"},
+		{"invalid puid", newLabelValues(PUID_PATTERN), "UNKNOWN", METRIC_LABEL_OTHER},
+		{"mime type", newLabelValues(MIME_TYPE_PATTERN), "application/pdf", "application/pdf"},
+		{"mime type with parameter", newLabelValues(MIME_TYPE_PATTERN), "Text/Plain; charset=UTF-8", "text/plain"},
+		{"unknown top-level type", newLabelValues(MIME_TYPE_PATTERN), "foo/bar", METRIC_LABEL_OTHER},
+		{"free text", newLabelValues(MIME_TYPE_PATTERN), "could not identify", METRIC_LABEL_OTHER},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.labels.normalize(test.value); got != test.wantLabel {
+				t.Errorf("got label %q, want %q", got, test.wantLabel)
+			}
+		})
+	}
+}
+
+func TestLabelValuesLimit(t *testing.T) {
+	labels := newLabelValues(PUID_PATTERN)
+	for i := range MAX_METRIC_LABEL_VALUES {
+		labels.normalize(fmt.Sprintf("fmt/%d", i))
+	}
+	if got := labels.normalize("fmt/1"); got != "fmt/1" {
+		t.Errorf("got label %q for a known value, want fmt/1", got)
+	}
+	if got := labels.normalize("x-fmt/1"); got != METRIC_LABEL_OTHER {
+		t.Errorf("got label %q beyond the limit, want %q", got, METRIC_LABEL_OTHER)
+	}
+}
+
+func TestRunToolCountsReportedTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error": {"code": "timeout", "message": "timeout exceeded"}}`))
+	}))
+	defer server.Close()
+	counter := toolErrorsTotal.WithLabelValues("metrics-test", TOOL_ERROR_TIMEOUT)
+	before := testutil.ToFloat64(counter)
+	runTool(
+		AnalysisRequest{Config: &ServerConfig{}, Filename: "file.pdf"},
+		ToolConfig{Id: "metrics-test", Endpoint: server.URL},
+		nil,
+	)
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("got %v counted timeouts, want 1", got)
+	}
+}
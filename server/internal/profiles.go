@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Reasons why a configured tool was not used in an analysis.
+const (
+	// SKIPPED_DISABLED means that the tool is disabled in the configuration.
+	SKIPPED_DISABLED = "disabled"
+	// SKIPPED_BY_PROFILE means that the analysis profile excludes the tool.
+	SKIPPED_BY_PROFILE = "profile"
+	// SKIPPED_BY_REQUEST means that the request parameters exclude the tool.
+	SKIPPED_BY_REQUEST = "request"
+	// SKIPPED_NOT_TRIGGERED means that no trigger of the tool was fulfilled.
+	SKIPPED_NOT_TRIGGERED = "notTriggered"
+)
+
+// AnalysisProfile is a named selection of tools. If Include is set, only the
+// listed tools are used. Tools listed in Exclude are never used. Triggered
+// tools still require one of their triggers to be fulfilled.
+type AnalysisProfile struct {
+	Id      string   `yaml:"id"`
+	Title   string   `yaml:"title"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	line    int
+}
+
+// ToolSelection restricts the tools of an analysis with an analysis profile
+// and tool ids given by the client. The default profile of the configuration
+// is used if Profile is empty.
+type ToolSelection struct {
+	Profile string   `json:"profile"`
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// SkippedTool is a configured tool that was not used in an analysis.
+type SkippedTool struct {
+	Id string `json:"id"`
+	// Reason is one of "disabled", "profile", "request" and "notTriggered".
+	Reason string `json:"reason"`
+}
+
+// CheckToolSelection ensures that the profile and all tool ids of a selection
+// are configured.
+func (c *ServerConfig) CheckToolSelection(s ToolSelection) error {
+	if s.Profile != "" {
+		_, ok := c.getProfile(s.Profile)
+		if !ok {
+			return fmt.Errorf("unknown analysis profile: %s", s.Profile)
+		}
+	}
+	for _, id := range slices.Concat(s.Include, s.Exclude) {
+		_, ok := c.getToolConfig(id)
+		if !ok {
+			return fmt.Errorf("unknown tool: %s", id)
+		}
+	}
+	return nil
+}
+
+// GetProfileId returns the id of the analysis profile used for a selection or
+// an empty string if no profile is used.
+func (c *ServerConfig) GetProfileId(s ToolSelection) string {
+	profile, ok := c.getSelectedProfile(s)
+	if !ok {
+		return ""
+	}
+	return profile.Id
+}
+
+func (c *ServerConfig) getSelectedProfile(s ToolSelection) (AnalysisProfile, bool) {
+	if s.Profile != "" {
+		return c.getProfile(s.Profile)
+	}
+	if c.DefaultProfile != "" {
+		return c.getProfile(c.DefaultProfile)
+	}
+	return AnalysisProfile{}, false
+}
+
+func (c *ServerConfig) getProfile(id string) (AnalysisProfile, bool) {
+	for _, profile := range c.Profiles {
+		if profile.Id == id {
+			return profile, true
+		}
+	}
+	return AnalysisProfile{}, false
+}
+
+// getSkipReason returns why a tool can't be used with a selection, or an empty
+// string if the tool can be used.
+func (c *ServerConfig) getSkipReason(s ToolSelection, tool ToolConfig) string {
+	if !tool.Enabled {
+		return SKIPPED_DISABLED
+	}
+	profile, ok := c.getSelectedProfile(s)
+	if ok && !isIncluded(tool.Id, profile.Include, profile.Exclude) {
+		return SKIPPED_BY_PROFILE
+	}
+	if !isIncluded(tool.Id, s.Include, s.Exclude) {
+		return SKIPPED_BY_REQUEST
+	}
+	return ""
+}
+
+func isIncluded(toolId string, include []string, exclude []string) bool {
+	if len(include) > 0 && !slices.Contains(include, toolId) {
+		return false
+	}
+	return !slices.Contains(exclude, toolId)
+}
+
+// GetSkippedTools returns all configured tools without a result and the reason
+// why they were not used.
+func GetSkippedTools(
+	config *ServerConfig,
+	selection ToolSelection,
+	toolResults map[string]ToolResult,
+) []SkippedTool {
+	skippedTools := make([]SkippedTool, 0)
+	for _, tool := range config.Tools {
+		if _, ok := toolResults[tool.Id]; ok {
+			continue
+		}
+		reason := config.getSkipReason(selection, tool)
+		if reason == "" {
+			reason = SKIPPED_NOT_TRIGGERED
+		}
+		skippedTools = append(skippedTools, SkippedTool{Id: tool.Id, Reason: reason})
+	}
+	return skippedTools
+}
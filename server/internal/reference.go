@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrPathNotAllowed = errors.New("path is not located in an allowed directory")
+	ErrNotRegularFile = errors.New("path is not a regular file")
+)
+
+// ResolveReference resolves a path requested by a client to a file in one of
+// the configured reference roots. Symbolic links and relative path elements
+// are resolved before the path is checked against the roots.
+//
+// It returns the path of the file relative to the file store, which is the
+// form expected by the tools.
+func (c *ServerConfig) ResolveReference(fileStorePath string, requestedPath string) (string, error) {
+	// Check the path before accessing the file system, so that clients can't
+	// probe for files outside of the reference roots.
+	cleanPath := filepath.Clean(requestedPath)
+	if !filepath.IsAbs(cleanPath) || !c.isInReferenceRoot(cleanPath, false) {
+		return "", ErrPathNotAllowed
+	}
+	resolvedPath, err := filepath.EvalSymlinks(cleanPath)
+	if err != nil {
+		return "", err
+	}
+	if !c.isInReferenceRoot(resolvedPath, true) {
+		return "", ErrPathNotAllowed
+	}
+	info, err := os.Stat(resolvedPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", ErrNotRegularFile
+	}
+	resolvedStore, err := filepath.EvalSymlinks(fileStorePath)
+	if err != nil {
+		return "", err
+	}
+	relativePath, err := filepath.Rel(resolvedStore, resolvedPath)
+	if err != nil || !filepath.IsLocal(relativePath) {
+		return "", ErrPathNotAllowed
+	}
+	return filepath.ToSlash(relativePath), nil
+}
+
+// isInReferenceRoot reports whether path is located in one of the configured
+// reference roots. If resolveRoots is set, symbolic links in the roots are
+// resolved before the comparison.
+func (c *ServerConfig) isInReferenceRoot(path string, resolveRoots bool) bool {
+	for _, root := range c.ReferenceRoots {
+		if resolveRoots {
+			resolvedRoot, err := filepath.EvalSymlinks(root)
+			if err != nil {
+				continue
+			}
+			root = resolvedRoot
+		}
+		if isLocalTo(filepath.Clean(root), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckReferenceRoots ensures that all configured reference roots are located
+// inside the file store, so that the tools are able to access their files.
+func (c *ServerConfig) CheckReferenceRoots(fileStorePath string) error {
+	for _, root := range c.ReferenceRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("reference root is not an absolute path: %s", root)
+		}
+		if !isLocalTo(fileStorePath, filepath.Clean(root)) {
+			return fmt.Errorf("reference root is not located in the file store: %s", root)
+		}
+	}
+	return nil
+}
+
+// isLocalTo reports whether path is located inside of dir. The directory
+// itself is not considered to be inside of it.
+func isLocalTo(dir string, path string) bool {
+	relativePath, err := filepath.Rel(dir, path)
+	return err == nil && relativePath != "." && filepath.IsLocal(relativePath)
+}
@@ -1,9 +1,21 @@
 package internal
 
-import "log"
+import (
+	"log"
+	"slices"
+)
 
 const UNCERTAIN_THRESHOLD = 0.75
 
+// CONFLICT_THRESHOLD is the minimum score of the feature sets that are
+// compared for conflicting validation results. Since the scores are
+// normalized, it is lower than UNCERTAIN_THRESHOLD.
+const CONFLICT_THRESHOLD = 0.25
+
+// CONFLICT_FEATURES are the features that identify the file format. Differing
+// values of these features in the top set are reported as feature conflicts.
+var CONFLICT_FEATURES = []string{"format:puid", "format:mimeType", "format:version"}
+
 // Summary accumulates validation results on the highest level.
 //
 // All values are calculated with simple rules from the extracted and scored
@@ -23,18 +35,68 @@ type Summary struct {
 	// ValidityConflict means there have been conflicting validation results
 	// from tools with sufficient confidence.
 	ValidityConflict bool `json:"validityConflict"`
-	// Error means that one or more tools aborted with an error.
-	Error bool `json:"error"`
+	// FeatureConflicts means that the tools supporting the feature set with
+	// the highest score provided different values for the PUID, MIME type or
+	// format version. The losing values are listed as alternatives of the
+	// feature.
+	FeatureConflicts bool `json:"featureConflicts"`
+	// Errors lists the tools that aborted with an error.
+	Errors []SummaryError `json:"errors"`
 	// PUID is the extracted PUID with the highest score.
 	PUID *string `json:"puid"`
 	// MimeType is the extracted mime type with the highest score.
 	MimeType *string `json:"mimeType"`
 	// FormatVersion is the extracted format version with the highest score.
 	FormatVersion *string `json:"formatVersion"`
+	// ChecksumMismatch lists the hash algorithms for which the checksum of the
+	// file didn't match the digest expected by the client.
+	ChecksumMismatch []string `json:"checksumMismatch"`
+	// Validators lists the verdicts of all tools that checked the validity or
+	// well-formedness of the file.
+	Validators []ValidatorVerdict `json:"validators"`
+}
+
+// ValidatorVerdict is the result of a single validating tool.
+type ValidatorVerdict struct {
+	ToolId string `json:"toolId"`
+	// Profile is the profile or module the tool checked, empty if the tool
+	// configuration doesn't name it.
+	Profile    string `json:"profile"`
+	Valid      *bool  `json:"valid"`
+	WellFormed *bool  `json:"wellFormed"`
+	// SupportsTopSet means that the tool supports the feature set with the
+	// highest score, which determines Valid and Invalid of the summary.
+	SupportsTopSet bool `json:"supportsTopSet"`
 }
 
-func GetSummary(sets []FeatureSet, toolResults []ToolResult) Summary {
-	var summary Summary
+// SummaryError is the error of a single tool.
+type SummaryError struct {
+	ToolId  string `json:"toolId"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func GetSummary(config *ServerConfig, sets []FeatureSet, toolResults []ToolResult) Summary {
+	summary := Summary{
+		Errors: make([]SummaryError, 0),
+	}
+	var topSetTools []string
+	if len(sets) > 0 {
+		topSetTools = sets[0].SupportingTools
+	}
+	summary.Validators = getValidatorVerdicts(config, toolResults, topSetTools)
+	summary.ValidityConflict = hasValidityConflict(sets, summary.Validators)
+	for _, result := range toolResults {
+		// errors of tools replaced by a fallback only count if the fallback
+		// failed as well
+		if result.Error != nil && result.ReplacedBy == "" {
+			summary.Errors = append(summary.Errors, SummaryError{
+				ToolId:  result.Id,
+				Code:    result.Error.Code,
+				Message: result.Error.Message,
+			})
+		}
+	}
 	if len(sets) == 0 {
 		summary.FormatUncertain = true
 		return summary
@@ -42,11 +104,12 @@ func GetSummary(sets []FeatureSet, toolResults []ToolResult) Summary {
 	if sets[0].Score < UNCERTAIN_THRESHOLD {
 		summary.FormatUncertain = true
 	}
+	summary.FeatureConflicts = hasFeatureConflicts(sets[0])
 	validFeature, ok := sets[0].Features["format:valid"]
 	if ok {
 		valid, ok := validFeature.Value.(bool)
 		if !ok {
-			log.Fatal("valid feature has non boolean value")
+			log.Println("valid feature has non boolean value")
 		} else if valid {
 			summary.Valid = true
 		} else {
@@ -57,7 +120,7 @@ func GetSummary(sets []FeatureSet, toolResults []ToolResult) Summary {
 	if ok {
 		puid, ok := puidFeature.Value.(string)
 		if !ok {
-			log.Fatal("PUID feature has non string value")
+			log.Println("PUID feature has non string value")
 		} else {
 			summary.PUID = &puid
 		}
@@ -66,7 +129,7 @@ func GetSummary(sets []FeatureSet, toolResults []ToolResult) Summary {
 	if ok {
 		mimeType, ok := mimeTypeFeature.Value.(string)
 		if !ok {
-			log.Fatal("MIME type feature has non string value")
+			log.Println("MIME type feature has non string value")
 		} else {
 			summary.MimeType = &mimeType
 		}
@@ -75,16 +138,164 @@ func GetSummary(sets []FeatureSet, toolResults []ToolResult) Summary {
 	if ok {
 		formatVersion, ok := formatVersionFeature.Value.(string)
 		if !ok {
-			log.Fatal("format version feature has non string value")
+			log.Println("format version feature has non string value")
 		} else {
 			summary.FormatVersion = &formatVersion
 		}
 	}
+	return summary
+}
+
+// getValidatorVerdicts collects the verdicts of all tool results with a valid
+// or well-formed feature, in the order of the tool results.
+func getValidatorVerdicts(
+	config *ServerConfig,
+	toolResults []ToolResult,
+	topSetTools []string,
+) []ValidatorVerdict {
+	verdicts := make([]ValidatorVerdict, 0)
 	for _, result := range toolResults {
 		if result.Error != nil {
-			summary.Error = true
-			break
+			continue
+		}
+		valid := getBoolFeature(result.Features, "format:valid")
+		wellFormed := getBoolFeature(result.Features, "format:wellFormed")
+		if valid == nil && wellFormed == nil {
+			continue
+		}
+		verdict := ValidatorVerdict{
+			ToolId:         result.Id,
+			Valid:          valid,
+			WellFormed:     wellFormed,
+			SupportsTopSet: slices.Contains(topSetTools, result.Id),
+		}
+		toolConfig, ok := config.getToolConfig(result.Id)
+		if ok {
+			verdict.Profile = toolConfig.ValidationProfile
 		}
+		verdicts = append(verdicts, verdict)
 	}
-	return summary
+	return verdicts
+}
+
+func getBoolFeature(features map[string]ToolFeatureValue, key string) *bool {
+	feature, ok := features[key]
+	if !ok {
+		return nil
+	}
+	value, ok := feature.Value.(bool)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// hasValidityConflict reports whether the validators supporting the top set
+// disagree, or whether feature sets with a score of at least
+// CONFLICT_THRESHOLD contain different validity values.
+func hasValidityConflict(sets []FeatureSet, verdicts []ValidatorVerdict) bool {
+	var topSetValues []bool
+	for _, verdict := range verdicts {
+		if verdict.SupportsTopSet && verdict.Valid != nil {
+			topSetValues = append(topSetValues, *verdict.Valid)
+		}
+	}
+	if slices.Contains(topSetValues, true) && slices.Contains(topSetValues, false) {
+		return true
+	}
+	var setValues []bool
+	for i, s := range sets {
+		if i > 0 && s.Score < CONFLICT_THRESHOLD {
+			continue
+		}
+		feature, ok := s.Features["format:valid"]
+		if !ok {
+			continue
+		}
+		valid, ok := feature.Value.(bool)
+		if ok {
+			setValues = append(setValues, valid)
+		}
+	}
+	return slices.Contains(setValues, true) && slices.Contains(setValues, false)
+}
+
+// BatchSummary aggregates the summaries of multiple analyzed files.
+type BatchSummary struct {
+	// FileCount is the number of analyzed files.
+	FileCount int `json:"fileCount"`
+	// Valid is the number of files that were identified as valid.
+	Valid int `json:"valid"`
+	// Invalid is the number of files that were identified as invalid.
+	Invalid int `json:"invalid"`
+	// FormatUncertain is the number of files whose format could not be
+	// identified with sufficient confidence.
+	FormatUncertain int `json:"formatUncertain"`
+	// ValidityConflict is the number of files with conflicting validation
+	// results.
+	ValidityConflict int `json:"validityConflict"`
+	// FeatureConflicts is the number of files for which the tools disagreed on
+	// the PUID, MIME type or format version.
+	FeatureConflicts int `json:"featureConflicts"`
+	// Error is the number of files for which one or more tools aborted with an
+	// error.
+	Error int `json:"error"`
+	// ChecksumMismatch is the number of files whose checksums didn't match the
+	// expected digests.
+	ChecksumMismatch int `json:"checksumMismatch"`
+	// PUIDs maps every identified PUID to the number of files with that PUID.
+	PUIDs map[string]int `json:"puids"`
+	// MimeTypes maps every identified MIME type to the number of files with
+	// that MIME type.
+	MimeTypes map[string]int `json:"mimeTypes"`
+}
+
+func GetBatchSummary(summaries []Summary) BatchSummary {
+	batchSummary := BatchSummary{
+		FileCount: len(summaries),
+		PUIDs:     make(map[string]int),
+		MimeTypes: make(map[string]int),
+	}
+	for _, summary := range summaries {
+		if summary.Valid {
+			batchSummary.Valid++
+		}
+		if summary.Invalid {
+			batchSummary.Invalid++
+		}
+		if summary.FormatUncertain {
+			batchSummary.FormatUncertain++
+		}
+		if summary.ValidityConflict {
+			batchSummary.ValidityConflict++
+		}
+		if summary.FeatureConflicts {
+			batchSummary.FeatureConflicts++
+		}
+		if len(summary.Errors) > 0 {
+			batchSummary.Error++
+		}
+		if len(summary.ChecksumMismatch) > 0 {
+			batchSummary.ChecksumMismatch++
+		}
+		if summary.PUID != nil {
+			batchSummary.PUIDs[*summary.PUID]++
+		}
+		if summary.MimeType != nil {
+			batchSummary.MimeTypes[*summary.MimeType]++
+		}
+	}
+	return batchSummary
+}
+
+// hasFeatureConflicts reports whether the tools of a set disagree on one of
+// the features identifying the file format.
+func hasFeatureConflicts(s FeatureSet) bool {
+	for _, key := range CONFLICT_FEATURES {
+		feature, ok := s.Features[key]
+		if ok && len(feature.Alternatives) > 0 {
+			return true
+		}
+	}
+	return false
 }
@@ -1,17 +1,29 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"maps"
 	"net/http"
 	"net/http/httputil"
+	"reflect"
 	"slices"
 	"sort"
 	"time"
 )
 
+const (
+	// DEFAULT_TOOL_TIMEOUT is the timeout of tools without a configured
+	// timeout. It matches the default timeout of the tool wrappers.
+	DEFAULT_TOOL_TIMEOUT = 60 * time.Second
+	// TOOL_TIMEOUT_MARGIN is added to the timeout of a tool for the request
+	// of the tool wrapper, so that the wrapper can report the timeout itself.
+	TOOL_TIMEOUT_MARGIN = 5 * time.Second
+)
+
 type ToolResult struct {
 	Id    string `json:"id"`
 	Title string `json:"title"`
@@ -26,20 +38,53 @@ type ToolResult struct {
 	// Features is a list of features as extracted from the tool's output.
 	Features map[string]ToolFeatureValue `json:"features"`
 	// Score is the from the tool supplied confidence of the result.
-	Score *float64 `json:"-"`
+	Score *float64 `json:"score"`
 	// ResponseTime
 	ResponseTimeInMs int64 `json:"responseTimeInMs"`
 	// Error is an error emitted from the tool in case of failure.
-	Error *string `json:"error"`
+	Error *ToolError `json:"error"`
+	// Cached means that the result was taken from the cache instead of
+	// requesting the tool.
+	Cached bool `json:"cached"`
+	// TriggeredBy describes why the tool was triggered. It is nil for
+	// identification tools.
+	TriggeredBy *TriggerCause `json:"triggeredBy"`
+	// ReplacedBy is the id of the fallback tool that ran because this tool
+	// failed or was skipped by the circuit breaker.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+	// FallbackFor is the id of the tool this tool replaced.
+	FallbackFor string `json:"fallbackFor,omitempty"`
 }
 
+// ToolError describes why a tool failed. It is shared with the tool wrappers.
+type ToolError = toolapi.ToolError
+
+// toolRequestStatus classifies the outcome of the request of a tool wrapper.
+type toolRequestStatus int
+
+const (
+	// TOOL_REQUEST_OK means that the wrapper responded, even if the tool
+	// reported an error for the file.
+	TOOL_REQUEST_OK toolRequestStatus = iota
+	// TOOL_REQUEST_FAILED means that the wrapper couldn't be reached,
+	// responded with a server error or reported that the tool failed.
+	TOOL_REQUEST_FAILED
+	// TOOL_REQUEST_TIMEOUT means that the wrapper didn't respond in time or
+	// reported that the tool exceeded the timeout.
+	TOOL_REQUEST_TIMEOUT
+	// TOOL_REQUEST_CANCELED means that the analysis was canceled.
+	TOOL_REQUEST_CANCELED
+)
+
 type ToolResponse struct {
 	ToolVersion  string                      `json:"toolVersion"`
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *ToolError                  `json:"error"`
 	Score        *float64                    `json:"score"`
+	// errorKind classifies the error for the metrics.
+	errorKind string
 }
 
 type ToolFeatureValue struct {
@@ -49,134 +94,409 @@ type ToolFeatureValue struct {
 
 type ByTitle []ToolResult
 
-func (a ByTitle) Len() int           { return len(a) }
-func (a ByTitle) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByTitle) Less(i, j int) bool { return a[i].Title < a[j].Title }
+func (a ByTitle) Len() int      { return len(a) }
+func (a ByTitle) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByTitle) Less(i, j int) bool {
+	if a[i].Title != a[j].Title {
+		return a[i].Title < a[j].Title
+	}
+	return a[i].Id < a[j].Id
+}
+
+// Progress is notified while the tools of an analysis are running. The
+// methods are called concurrently from the goroutines requesting the tools.
+type Progress interface {
+	// ToolStarted is called before a tool is requested.
+	ToolStarted(toolId string)
+	// ToolFinished is called as soon as the result of a tool is available.
+	ToolFinished(result ToolResult)
+	// ToolsTriggered is called with the ids of all tools triggered in a round,
+	// before they are requested.
+	ToolsTriggered(toolIds []string)
+}
+
+// AnalysisRequest describes the analysis of a single file.
+type AnalysisRequest struct {
+	// Context cancels all outstanding tool requests, for example if the client
+	// disconnects. It is optional.
+	Context context.Context
+	// Config is the server configuration used for the whole analysis, even if
+	// the configuration is reloaded in the meantime.
+	Config *ServerConfig
+	// Filename is the path of the file relative to the file store.
+	Filename string
+	// Progress is optional and receives updates while the tools are running.
+	Progress Progress
+	// Checksums are the checksums of the file. Tool results are cached by the
+	// SHA-256 digest. The cache isn't used if the digest is missing.
+	Checksums Checksums
+	// ExpectedChecksums are digests supplied by the client, which are verified
+	// against the checksums of the file.
+	ExpectedChecksums Checksums
+	// BypassCache forces all tools to be requested. The new results replace
+	// the cached ones.
+	BypassCache bool
+	// Tools restricts the tools used for the analysis.
+	Tools ToolSelection
+	// Explain records how the feature sets were merged.
+	Explain bool
+	// FileFeatures are features determined by Borg itself, like the size of
+	// the file. They are available to the conditions of triggers.
+	FileFeatures map[string]ToolFeatureValue
+}
 
-func RunIdentificationTools(filename string) map[string]ToolResult {
-	var responseChannels []chan ToolResult
-	// for every identification tool
-	for _, tool := range serverConfig.Tools {
-		if !tool.Enabled || len(tool.Triggers) > 0 {
+func RunIdentificationTools(request AnalysisRequest) map[string]ToolResult {
+	var identificationTools []ToolConfig
+	for _, tool := range request.Config.Tools {
+		if len(tool.Triggers) > 0 || request.Config.getSkipReason(request.Tools, tool) != "" {
 			continue
 		}
-		rc := make(chan ToolResult)
-		responseChannels = append(responseChannels, rc)
-		// request tool results concurrent
-		go func() {
-			start := time.Now()
-			response := getToolResult(tool.Endpoint, filename)
-			features := make(map[string]ToolFeatureValue)
-			if len(response.Features) > 0 {
-				features = response.Features
-			}
-			rc <- ToolResult{
-				Id:               tool.Id,
-				Title:            tool.Title,
-				ToolVersion:      response.ToolVersion,
-				ToolOutput:       response.ToolOutput,
-				OutputFormat:     response.OutputFormat,
-				Features:         features,
-				Score:            response.Score,
-				Error:            response.Error,
-				ResponseTimeInMs: time.Since(start).Milliseconds(),
-			}
-		}()
+		identificationTools = append(identificationTools, tool)
 	}
-	// gather all tool responses
-	results := make(map[string]ToolResult)
-	for _, rc := range responseChannels {
-		toolResponse := <-rc
-		results[toolResponse.Id] = toolResponse
-	}
-	return results
+	return runTools(request, identificationTools, nil)
 }
 
+// RunTriggeredTools runs the triggered tools in rounds. Every round evaluates
+// the triggers against the results of the identification and all previous
+// rounds, so that triggered tools can trigger further tools. The number of
+// rounds and the number of runs of a single tool are limited by the
+// configuration.
+//
+// It returns the latest result of every triggered tool and the ids of the
+// tools run in every round.
 func RunTriggeredTools(
-	filename string,
+	request AnalysisRequest,
 	identificationResults map[string]ToolResult,
+) (map[string]ToolResult, [][]string) {
+	maxRounds, maxToolRuns := request.Config.getTriggerLimits()
+	allResults := maps.Clone(identificationResults)
+	results := make(map[string]ToolResult)
+	rounds := make([][]string, 0)
+	runs := make(map[string]int)
+	previousMatches := make(map[string]map[string]ToolFeatureValue)
+	for round := range maxRounds {
+		input := triggerInput{
+			toolResults:  allResults,
+			fileFeatures: request.FileFeatures,
+		}
+		// feature sets are only merged if a trigger evaluates them
+		if slices.ContainsFunc(request.Config.Tools, func(tc ToolConfig) bool {
+			return tc.usesFeatureSets()
+		}) {
+			input.featureSets = MergeFeatureSets(request.Config, allResults)
+		}
+		var triggeredTools []ToolConfig
+		var triggerCauses []*TriggerCause
+		for _, toolConfig := range request.Config.Tools {
+			if len(toolConfig.Triggers) == 0 ||
+				runs[toolConfig.Id] >= maxToolRuns ||
+				request.Config.getSkipReason(request.Tools, toolConfig) != "" {
+				continue
+			}
+			isTriggered, cause := toolConfig.IsTriggered(input)
+			if !isTriggered {
+				continue
+			}
+			// run a tool again only if other feature values triggered it
+			previous, ok := previousMatches[toolConfig.Id]
+			if ok && maps.EqualFunc(previous, cause.Matches, isEqualFeatureValue) {
+				continue
+			}
+			triggeredTools = append(triggeredTools, toolConfig)
+			triggerCauses = append(triggerCauses, cause)
+		}
+		toolIds := make([]string, 0, len(triggeredTools))
+		for i, toolConfig := range triggeredTools {
+			toolIds = append(toolIds, toolConfig.Id)
+			runs[toolConfig.Id]++
+			recordToolTriggered(toolConfig.Id)
+			previousMatches[toolConfig.Id] = triggerCauses[i].Matches
+		}
+		// the first round is always reported, even if no tool was triggered
+		if request.Progress != nil && (round == 0 || len(toolIds) > 0) {
+			request.Progress.ToolsTriggered(toolIds)
+		}
+		if len(triggeredTools) == 0 {
+			break
+		}
+		roundResults := runTools(request, triggeredTools, triggerCauses)
+		for id, result := range roundResults {
+			allResults[id] = result
+			results[id] = result
+		}
+		rounds = append(rounds, toolIds)
+	}
+	return results, rounds
+}
+
+func isEqualFeatureValue(v1 ToolFeatureValue, v2 ToolFeatureValue) bool {
+	return reflect.DeepEqual(v1.Value, v2.Value)
+}
+
+// runTools requests the results of the given tools concurrently. The trigger
+// causes are optional and correspond to the tools.
+func runTools(
+	request AnalysisRequest,
+	tools []ToolConfig,
+	triggerCauses []*TriggerCause,
 ) map[string]ToolResult {
-	var responseChannels []chan ToolResult
-	// for every identification tool
-	for _, toolConfig := range serverConfig.Tools {
-		isTriggered, matches := toolConfig.IsTriggered(identificationResults)
-		if !toolConfig.Enabled || len(toolConfig.Triggers) == 0 || !isTriggered {
-			continue
+	var responseChannels []chan []ToolResult
+	for i, toolConfig := range tools {
+		var cause *TriggerCause
+		if triggerCauses != nil {
+			cause = triggerCauses[i]
 		}
-		rc := make(chan ToolResult)
+		rc := make(chan []ToolResult)
 		responseChannels = append(responseChannels, rc)
 		// request tool results concurrent
 		go func() {
-			start := time.Now()
-			response := getToolResult(toolConfig.Endpoint, filename)
-			features := make(map[string]ToolFeatureValue)
-			if len(response.Features) > 0 {
-				features = response.Features
-			}
-			// get feature values from tool trigger
-			for _, featureConfig := range toolConfig.FeatureSet.Features {
-				if featureConfig.ProvidedByTrigger {
-					v, ok := matches[featureConfig.Key]
-					if ok {
-						features[featureConfig.Key] = ToolFeatureValue{
-							Value: v.Value,
-						}
-					}
-				}
-			}
-			rc <- ToolResult{
-				Id:               toolConfig.Id,
-				Title:            toolConfig.Title,
-				ToolVersion:      response.ToolVersion,
-				ToolOutput:       response.ToolOutput,
-				OutputFormat:     response.OutputFormat,
-				Features:         features,
-				Score:            response.Score,
-				Error:            response.Error,
-				ResponseTimeInMs: time.Duration(time.Since(start)).Milliseconds(),
-			}
+			rc <- runToolWithFallback(request, tools, toolConfig, cause)
 		}()
 	}
 	// gather all tool responses
 	results := make(map[string]ToolResult)
 	for _, rc := range responseChannels {
-		toolResponse := <-rc
-		results[toolResponse.Id] = toolResponse
+		for _, toolResponse := range <-rc {
+			results[toolResponse.Id] = toolResponse
+		}
 	}
 	return results
 }
 
+// runToolWithFallback runs a tool and, if the tool fails, its fallback tool.
+// The fallback receives the same trigger cause. The results are reported to
+// the progress.
+func runToolWithFallback(
+	request AnalysisRequest,
+	tools []ToolConfig,
+	toolConfig ToolConfig,
+	cause *TriggerCause,
+) []ToolResult {
+	result := runTool(request, toolConfig, cause)
+	fallback, hasFallback := request.Config.getFallback(request.Tools, toolConfig, tools)
+	if result.Error == nil || !hasFallback {
+		notifyToolFinished(request, result)
+		return []ToolResult{result}
+	}
+	log.Printf("tool %s failed, running fallback %s", toolConfig.Id, fallback.Id)
+	result.ReplacedBy = fallback.Id
+	notifyToolFinished(request, result)
+	fallbackResult := runTool(request, fallback, cause)
+	fallbackResult.FallbackFor = toolConfig.Id
+	notifyToolFinished(request, fallbackResult)
+	return []ToolResult{result, fallbackResult}
+}
+
+func notifyToolFinished(request AnalysisRequest, result ToolResult) {
+	if request.Progress != nil {
+		request.Progress.ToolFinished(result)
+	}
+}
+
+// runTool requests the result of a single tool. Tools are skipped while their
+// circuit breaker is open. Features with the option providedByTrigger are
+// taken from the matches of the trigger cause, which is nil for
+// identification tools.
+func runTool(
+	request AnalysisRequest,
+	toolConfig ToolConfig,
+	cause *TriggerCause,
+) ToolResult {
+	if request.Progress != nil {
+		request.Progress.ToolStarted(toolConfig.Id)
+	}
+	fileHash := request.Checksums[CHECKSUM_SHA256]
+	if fileHash != "" && !request.BypassCache {
+		result, ok := cache.get(request.Config.Cache, fileHash, toolConfig.Id)
+		if ok {
+			result.Cached = true
+			return withTriggerFeatures(result, toolConfig, cause)
+		}
+	}
+	if !breakers.allow(request.Config.CircuitBreaker, toolConfig.Id) {
+		recordToolResult(toolConfig.Id, 0, TOOL_ERROR_SKIPPED)
+		return ToolResult{
+			Id:          toolConfig.Id,
+			Title:       toolConfig.Title,
+			Features:    make(map[string]ToolFeatureValue),
+			Error:       toolapi.NewToolError(toolapi.ERROR_CODE_UNAVAILABLE, "tool skipped after repeated failures"),
+			TriggeredBy: cause,
+		}
+	}
+	ctx := request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := time.Now()
+	response, status := getToolResult(ctx, toolConfig, request.Filename)
+	breakers.record(request.Config.CircuitBreaker, toolConfig.Id, status, response.Error)
+	recordToolResult(toolConfig.Id, time.Since(start), response.errorKind)
+	features := make(map[string]ToolFeatureValue)
+	if len(response.Features) > 0 {
+		features = response.Features
+	}
+	result := ToolResult{
+		Id:               toolConfig.Id,
+		Title:            toolConfig.Title,
+		ToolVersion:      response.ToolVersion,
+		ToolOutput:       response.ToolOutput,
+		OutputFormat:     response.OutputFormat,
+		Features:         features,
+		Score:            response.Score,
+		Error:            response.Error,
+		ResponseTimeInMs: time.Since(start).Milliseconds(),
+	}
+	// the cache contains the result of the wrapper without the features of the
+	// trigger cause, because the tool may be triggered by other feature values
+	if fileHash != "" {
+		cache.put(request.Config.Cache, fileHash, result)
+	}
+	return withTriggerFeatures(result, toolConfig, cause)
+}
+
+// withTriggerFeatures returns a copy of the result with the features provided
+// by the trigger cause, which is nil for identification tools.
+func withTriggerFeatures(result ToolResult, toolConfig ToolConfig, cause *TriggerCause) ToolResult {
+	var matches map[string]ToolFeatureValue
+	if cause != nil {
+		matches = cause.Matches
+	}
+	features := make(map[string]ToolFeatureValue)
+	maps.Copy(features, result.Features)
+	addTriggerFeatures(toolConfig, features, matches)
+	result.Features = features
+	result.TriggeredBy = cause
+	return result
+}
+
+// addTriggerFeatures adds the features with the option providedByTrigger from
+// the trigger matches to the features of a tool result.
+func addTriggerFeatures(
+	toolConfig ToolConfig,
+	features map[string]ToolFeatureValue,
+	matches map[string]ToolFeatureValue,
+) {
+	for _, featureConfig := range toolConfig.FeatureSet.Features {
+		if featureConfig.ProvidedByTrigger {
+			v, ok := matches[featureConfig.Key]
+			if ok {
+				features[featureConfig.Key] = ToolFeatureValue{
+					Value: v.Value,
+				}
+			}
+		}
+	}
+}
+
+// getToolResult requests the tool wrapper and retries the request according
+// to the tool configuration. Timeouts, canceled requests and failures reported
+// by the wrapper aren't retried.
 func getToolResult(
-	endpoint string,
+	ctx context.Context,
+	toolConfig ToolConfig,
+	filename string,
+) (ToolResponse, toolRequestStatus) {
+	backoff := toolConfig.Backoff
+	for attempt := 0; ; attempt++ {
+		response, status := requestTool(ctx, toolConfig, filename)
+		if status != TOOL_REQUEST_FAILED ||
+			response.errorKind == TOOL_ERROR_TOOL ||
+			attempt >= toolConfig.Retries {
+			return response, status
+		}
+		log.Printf("retrying request of tool %s in %s", toolConfig.Id, backoff)
+		select {
+		case <-ctx.Done():
+			response.Error = toolapi.NewToolError(toolapi.ERROR_CODE_CANCELED, "analysis canceled")
+			response.errorKind = TOOL_ERROR_CANCELED
+			return response, TOOL_REQUEST_CANCELED
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// requestTool requests the tool wrapper once.
+func requestTool(
+	ctx context.Context,
+	toolConfig ToolConfig,
 	filename string,
-) ToolResponse {
+) (ToolResponse, toolRequestStatus) {
+	timeout := toolConfig.getTimeout()
+	requestCtx, cancel := context.WithTimeout(ctx, timeout+TOOL_TIMEOUT_MARGIN)
+	defer cancel()
 	// create http get request
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(requestCtx, "GET", toolConfig.Endpoint, nil)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error creating request: %s", endpoint)
-		return ToolResponse{Error: &errorMessage}
+		toolError := toolapi.NewToolError(
+			toolapi.ERROR_CODE_UNAVAILABLE,
+			fmt.Sprintf("error creating request: %s", toolConfig.Endpoint),
+		)
+		return ToolResponse{Error: toolError, errorKind: TOOL_ERROR_UNREACHABLE}, TOOL_REQUEST_FAILED
 	}
-	// add file path URL parameter
+	// add file path and timeout URL parameters
 	query := req.URL.Query()
 	query.Add("path", filename)
+	query.Add("timeout", timeout.String())
 	req.URL.RawQuery = query.Encode()
 	// send get request
-	response, err := http.Get(req.URL.String())
+	response, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error requesting: %s", req.URL.String())
-		return ToolResponse{Error: &errorMessage}
+		switch {
+		case ctx.Err() != nil:
+			toolError := toolapi.NewToolError(toolapi.ERROR_CODE_CANCELED, "analysis canceled")
+			return ToolResponse{Error: toolError, errorKind: TOOL_ERROR_CANCELED}, TOOL_REQUEST_CANCELED
+		case requestCtx.Err() == context.DeadlineExceeded:
+			toolError := toolapi.NewToolError(
+				toolapi.ERROR_CODE_TIMEOUT,
+				fmt.Sprintf("timeout exceeded after %s", timeout),
+			)
+			return ToolResponse{Error: toolError, errorKind: TOOL_ERROR_TIMEOUT}, TOOL_REQUEST_TIMEOUT
+		}
+		toolError := toolapi.NewToolError(
+			toolapi.ERROR_CODE_UNAVAILABLE,
+			fmt.Sprintf("error requesting: %s", req.URL.String()),
+		)
+		return ToolResponse{Error: toolError, errorKind: TOOL_ERROR_UNREACHABLE}, TOOL_REQUEST_FAILED
 	}
+	defer response.Body.Close()
 	// process request response
-	return processToolResponse(response)
+	if response.StatusCode >= http.StatusInternalServerError {
+		return processToolResponse(response), TOOL_REQUEST_FAILED
+	}
+	toolResponse := processToolResponse(response)
+	return toolResponse, getReportedStatus(toolResponse.Error)
+}
+
+// getReportedStatus classifies an error reported by the wrapper. The wrappers
+// respond with status 200 even if the tool failed or exceeded the timeout, but
+// these errors mean that the tool isn't working, unlike an unsupported file. A
+// reported cancellation isn't a failure of the tool.
+func getReportedStatus(toolError *ToolError) toolRequestStatus {
+	if toolError == nil {
+		return TOOL_REQUEST_OK
+	}
+	switch toolError.Code {
+	case toolapi.ERROR_CODE_TIMEOUT:
+		return TOOL_REQUEST_TIMEOUT
+	case toolapi.ERROR_CODE_TOOL_FAILED:
+		return TOOL_REQUEST_FAILED
+	case toolapi.ERROR_CODE_CANCELED:
+		return TOOL_REQUEST_CANCELED
+	}
+	return TOOL_REQUEST_OK
 }
 
 func processToolResponse(response *http.Response) ToolResponse {
 	if response.StatusCode != http.StatusOK {
-		errorMessage := fmt.Sprintf("tool request error: %d", response.StatusCode)
 		toolResponse := ToolResponse{
-			Error: &errorMessage,
+			Error: toolapi.NewToolError(
+				toolapi.ERROR_CODE_UNAVAILABLE,
+				fmt.Sprintf("tool request error: %d", response.StatusCode),
+			),
+			errorKind: TOOL_ERROR_HTTP_STATUS,
 		}
 		bytes, err := httputil.DumpResponse(response, true)
 		if err == nil {
@@ -192,22 +512,33 @@ func processToolResponse(response *http.Response) ToolResponse {
 		errorMessage := "error parsing tool response"
 		log.Println(errorMessage)
 		log.Println(err)
-		return ToolResponse{Error: &errorMessage}
+		return ToolResponse{
+			Error:     toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, errorMessage),
+			errorKind: TOOL_ERROR_PARSE,
+		}
+	}
+	if result.Error != nil {
+		result.errorKind = TOOL_ERROR_TOOL
+		switch result.Error.Code {
+		case toolapi.ERROR_CODE_TIMEOUT:
+			result.errorKind = TOOL_ERROR_TIMEOUT
+		case toolapi.ERROR_CODE_CANCELED:
+			result.errorKind = TOOL_ERROR_CANCELED
+		}
 	}
 	return result
 }
 
+// CombineToolResults combines the results of the identification and the
+// triggered tools. A triggered result replaces the identification result of
+// the same tool.
 func CombineToolResults(
 	identResults map[string]ToolResult,
 	triggeredResults map[string]ToolResult,
 ) map[string]ToolResult {
 	toolResults := make(map[string]ToolResult)
-	for k, v := range identResults {
-		toolResults[k] = v
-	}
-	for k, v := range triggeredResults {
-		toolResults[k] = v
-	}
+	maps.Copy(toolResults, identResults)
+	maps.Copy(toolResults, triggeredResults)
 	return toolResults
 }
 
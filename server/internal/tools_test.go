@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetSortedToolResults(t *testing.T) {
+	identResults := getTestToolResults([]ToolResult{
+		{Id: "siegfried", Title: "Siegfried"},
+		{Id: "droid-old", Title: "DROID"},
+		{Id: "droid", Title: "DROID"},
+		{Id: "magika", Title: "Magika"},
+	})
+	triggeredResults := getTestToolResults([]ToolResult{
+		{Id: "verapdf-ua", Title: "veraPDF"},
+		{Id: "verapdf", Title: "veraPDF"},
+		{Id: "jhove", Title: "JHOVE"},
+	})
+	want := []string{"droid", "droid-old", "magika", "siegfried", "jhove", "verapdf", "verapdf-ua"}
+	for range REPEATED_RUNS {
+		var got []string
+		for _, result := range GetSortedToolResults(identResults, triggeredResults) {
+			got = append(got, result.Id)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FEATURE_TYPES are the value types of features with a fixed meaning. The
+// validation rejects conditions that can never be fulfilled by values of these
+// types.
+var FEATURE_TYPES = map[string]string{
+	"format:puid":       "string",
+	"format:mimeType":   "string",
+	"format:version":    "string",
+	"format:name":       "string",
+	"format:valid":      "bool",
+	"format:wellFormed": "bool",
+	FILE_SIZE_FEATURE:   "number",
+}
+
+// ConfigError is a mistake in the server configuration. Line is the line in
+// the configuration file that contains the mistake, zero if it is unknown.
+type ConfigError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
+}
+
+type configValidator struct {
+	path string
+	// knownFeatures contains the keys of all features that are declared in the
+	// feature set of a tool.
+	knownFeatures map[string]bool
+	errors        []error
+}
+
+func (v *configValidator) addError(line int, format string, a ...any) {
+	v.errors = append(v.errors, ConfigError{
+		Path:    v.path,
+		Line:    line,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// Validate checks the configuration for mistakes that would otherwise only
+// show up during an analysis. It returns all found mistakes joined into a
+// single error.
+func (c *ServerConfig) Validate() error {
+	v := configValidator{
+		path:          c.path,
+		knownFeatures: make(map[string]bool),
+	}
+	if v.path == "" {
+		v.path = "server config"
+	}
+	for _, tool := range c.Tools {
+		for _, feature := range tool.FeatureSet.Features {
+			v.knownFeatures[feature.Key] = true
+		}
+	}
+	// features determined by Borg itself, available for triggers
+	v.knownFeatures[FILE_SIZE_FEATURE] = true
+	for _, algorithm := range CHECKSUM_ALGORITHMS {
+		v.knownFeatures["file:checksum:"+algorithm] = true
+	}
+	toolLines := make(map[string]int)
+	for i := range c.Tools {
+		v.validateTool(c, &c.Tools[i], toolLines)
+	}
+	for i := range c.FileIdentityRules {
+		rule := &c.FileIdentityRules[i]
+		if len(rule.Conditions) == 0 {
+			v.addError(rule.line, "file identity rule without conditions applies to every feature set")
+		}
+		for j := range rule.Conditions {
+			v.validateCondition(&rule.Conditions[j], "file identity rule", false)
+		}
+	}
+	profileLines := make(map[string]int)
+	for i := range c.Profiles {
+		v.validateProfile(c, &c.Profiles[i], profileLines)
+	}
+	if c.DefaultProfile != "" {
+		if _, ok := profileLines[c.DefaultProfile]; !ok {
+			v.addError(0, "unknown default profile %q", c.DefaultProfile)
+		}
+	}
+	if c.TriggerChaining != nil {
+		if c.TriggerChaining.MaxRounds < 1 {
+			v.addError(c.TriggerChaining.line, "triggerChaining: maxRounds must be at least 1")
+		}
+		if c.TriggerChaining.MaxToolRuns < 1 {
+			v.addError(c.TriggerChaining.line, "triggerChaining: maxToolRuns must be at least 1")
+		}
+	}
+	if c.CircuitBreaker != nil {
+		if c.CircuitBreaker.FailureThreshold < 1 {
+			v.addError(c.CircuitBreaker.line, "circuitBreaker: failureThreshold must be at least 1")
+		}
+		if c.CircuitBreaker.CoolDown <= 0 {
+			v.addError(c.CircuitBreaker.line, "circuitBreaker: coolDown must be positive")
+		}
+	}
+	if c.Archive != nil {
+		if c.Archive.MaxEntries < 0 {
+			v.addError(c.Archive.line, "archive: maxEntries must not be negative")
+		}
+		if c.Archive.MaxEntrySize < 0 {
+			v.addError(c.Archive.line, "archive: maxEntrySize must not be negative")
+		}
+		if c.Archive.MaxTotalSize < 0 {
+			v.addError(c.Archive.line, "archive: maxTotalSize must not be negative")
+		}
+	}
+	if c.Cache != nil {
+		if c.Cache.MaxEntries < 0 {
+			v.addError(c.Cache.line, "cache: maxEntries must not be negative")
+		}
+		if c.Cache.MaxAge < 0 {
+			v.addError(c.Cache.line, "cache: maxAge must not be negative")
+		}
+	}
+	return errors.Join(v.errors...)
+}
+
+func (v *configValidator) validateTool(
+	c *ServerConfig,
+	tool *ToolConfig,
+	toolLines map[string]int,
+) {
+	if tool.Id == "" {
+		v.addError(tool.line, "tool without id")
+	} else if line, ok := toolLines[tool.Id]; ok {
+		v.addError(tool.line, "duplicate tool id %q, first defined in line %d", tool.Id, line)
+	} else {
+		toolLines[tool.Id] = tool.line
+	}
+	if tool.Enabled {
+		endpoint, err := url.Parse(tool.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") ||
+			endpoint.Host == "" {
+			v.addError(tool.line, "tool %q: invalid endpoint %q", tool.Id, tool.Endpoint)
+		}
+	}
+	if tool.Timeout < 0 {
+		v.addError(tool.line, "tool %q: timeout must not be negative", tool.Id)
+	}
+	if tool.Retries < 0 {
+		v.addError(tool.line, "tool %q: retries must not be negative", tool.Id)
+	}
+	if tool.Backoff < 0 {
+		v.addError(tool.line, "tool %q: backoff must not be negative", tool.Id)
+	}
+	if tool.Required && !tool.Enabled {
+		v.addError(tool.line, "tool %q is required but disabled", tool.Id)
+	}
+	if tool.Fallback != "" {
+		if tool.Fallback == tool.Id {
+			v.addError(tool.line, "tool %q can't be its own fallback", tool.Id)
+		} else if _, ok := c.getToolConfig(tool.Fallback); !ok {
+			v.addError(tool.line, "tool %q: unknown fallback tool %q", tool.Id, tool.Fallback)
+		}
+	}
+	featureLines := make(map[string]int)
+	for i := range tool.FeatureSet.Features {
+		feature := &tool.FeatureSet.Features[i]
+		if feature.Key == "" {
+			v.addError(feature.line, "tool %q: feature without key", tool.Id)
+			continue
+		}
+		if line, ok := featureLines[feature.Key]; ok {
+			v.addError(
+				feature.line,
+				"tool %q: duplicate feature %q, first defined in line %d",
+				tool.Id,
+				feature.Key,
+				line,
+			)
+		}
+		featureLines[feature.Key] = feature.line
+		if feature.MergeCondition != nil {
+			v.validateMergeCondition(feature.MergeCondition, tool.Id, feature.Key)
+		}
+	}
+	for i := range tool.Triggers {
+		trigger := &tool.Triggers[i]
+		if len(trigger.Conditions) == 0 {
+			v.addError(trigger.line, "tool %q: trigger without conditions", tool.Id)
+		}
+		switch trigger.Mode {
+		case "", TRIGGER_MODE_TOOL_RESULTS:
+			if trigger.MinScore != 0 {
+				v.addError(
+					trigger.line,
+					"tool %q: minScore is only used by triggers with mode %q",
+					tool.Id,
+					TRIGGER_MODE_FEATURE_SETS,
+				)
+			}
+		case TRIGGER_MODE_FEATURE_SETS:
+			if trigger.MinScore < 0.0 || trigger.MinScore > 1.0 {
+				v.addError(trigger.line, "tool %q: trigger minScore %v is not between 0 and 1", tool.Id, trigger.MinScore)
+			}
+		default:
+			v.addError(trigger.line, "tool %q: unknown trigger mode %q", tool.Id, trigger.Mode)
+		}
+		for j := range trigger.Conditions {
+			v.validateCondition(
+				&trigger.Conditions[j],
+				fmt.Sprintf("tool %q: trigger condition", tool.Id),
+				true,
+			)
+		}
+	}
+	v.validateWeight(&tool.FeatureSet.Weight, tool)
+}
+
+func (v *configValidator) validateProfile(
+	c *ServerConfig,
+	profile *AnalysisProfile,
+	profileLines map[string]int,
+) {
+	if profile.Id == "" {
+		v.addError(profile.line, "profile without id")
+	} else if line, ok := profileLines[profile.Id]; ok {
+		v.addError(profile.line, "duplicate profile id %q, first defined in line %d", profile.Id, line)
+	} else {
+		profileLines[profile.Id] = profile.line
+	}
+	for _, id := range slices.Concat(profile.Include, profile.Exclude) {
+		if _, ok := c.getToolConfig(id); !ok {
+			v.addError(profile.line, "profile %q references unknown tool %q", profile.Id, id)
+		}
+	}
+}
+
+func (v *configValidator) validateMergeCondition(
+	c *MergeCondition,
+	toolId string,
+	featureKey string,
+) {
+	if c.ValueRegEx == nil {
+		return
+	}
+	regEx, err := regexp.Compile(*c.ValueRegEx)
+	if err != nil {
+		v.addError(c.line, "tool %q: invalid valueRegEx for feature %q: %v", toolId, featureKey, err)
+		return
+	}
+	if regEx.NumSubexp() != 1 {
+		v.addError(
+			c.line,
+			"tool %q: valueRegEx for feature %q must contain exactly one capture group",
+			toolId,
+			featureKey,
+		)
+	}
+	featureType, ok := FEATURE_TYPES[featureKey]
+	if ok && featureType != "string" {
+		v.addError(
+			c.line,
+			"tool %q: valueRegEx can't be used for %s feature %q",
+			toolId,
+			featureType,
+			featureKey,
+		)
+	}
+}
+
+// validateCondition checks a condition and its nested conditions. Features
+// determined by Borg itself are only available if fileFeatures is set.
+func (v *configValidator) validateCondition(
+	c *FeatureCondition,
+	context string,
+	fileFeatures bool,
+) {
+	v.validateNestedCondition(c, context, "", fileFeatures)
+}
+
+// validateNestedCondition checks a condition that tests the feature of the
+// enclosing condition unless it names its own feature.
+func (v *configValidator) validateNestedCondition(
+	c *FeatureCondition,
+	context string,
+	feature string,
+	fileFeatures bool,
+) {
+	isGroup := c.Not != nil || len(c.AnyOf) > 0 || len(c.AllOf) > 0
+	hasTest := c.testsValue() || c.Exists || c.Absent
+	if c.Feature != "" {
+		feature = c.Feature
+		isFileFeature := strings.HasPrefix(feature, "file:")
+		if isFileFeature && !fileFeatures {
+			v.addError(c.line, "%s can't use feature %q, which is only available for triggers", context, feature)
+		} else if !v.knownFeatures[feature] {
+			v.addError(
+				c.line,
+				"%s references unknown feature %q, which is not declared in the feature set of any tool",
+				context,
+				feature,
+			)
+		}
+	}
+	if feature == "" && (hasTest || !isGroup) {
+		v.addError(c.line, "%s without feature", context)
+	} else if !hasTest && !isGroup {
+		v.addError(c.line, "%s has no test for feature %q", context, feature)
+	}
+	if c.Exists && c.Absent {
+		v.addError(c.line, "%s has both exists and absent", context)
+	}
+	if c.Absent && c.testsValue() {
+		v.addError(c.line, "%s tests the value of an absent feature", context)
+	}
+	if c.Between != nil {
+		if len(c.Between) != 2 {
+			v.addError(c.line, "%s: between needs exactly two numbers", context)
+		} else if c.Between[0] > c.Between[1] {
+			v.addError(c.line, "%s: between has a lower bound greater than the upper bound", context)
+		}
+	}
+	if c.Lt != nil && c.Gt != nil && *c.Gt >= *c.Lt {
+		v.addError(c.line, "%s: gt %v and lt %v can never be fulfilled together", context, *c.Gt, *c.Lt)
+	}
+	featureType, hasType := FEATURE_TYPES[feature]
+	if c.RegEx != nil {
+		_, err := regexp.Compile(*c.RegEx)
+		if err != nil {
+			v.addError(c.line, "%s has an invalid regEx: %v", context, err)
+		}
+		if hasType && featureType != "string" {
+			v.addError(
+				c.line,
+				"%s uses a regEx for %s feature %q",
+				context,
+				featureType,
+				feature,
+			)
+		}
+	}
+	if hasType {
+		for _, value := range slices.Concat([]interface{}{c.Value}, c.In) {
+			if value != nil && getValueType(value) != featureType {
+				v.addError(
+					c.line,
+					"%s compares %s feature %q with value %v",
+					context,
+					featureType,
+					feature,
+					value,
+				)
+			}
+		}
+		if c.testsNumber() && featureType != "number" {
+			v.addError(
+				c.line,
+				"%s compares %s feature %q with a number",
+				context,
+				featureType,
+				feature,
+			)
+		}
+	}
+	if c.Not != nil {
+		v.validateNestedCondition(c.Not, context, feature, fileFeatures)
+	}
+	for i := range c.AnyOf {
+		v.validateNestedCondition(&c.AnyOf[i], context, feature, fileFeatures)
+	}
+	for i := range c.AllOf {
+		v.validateNestedCondition(&c.AllOf[i], context, feature, fileFeatures)
+	}
+}
+
+func (v *configValidator) validateWeight(w *Weight, tool *ToolConfig) {
+	line := w.line
+	if line == 0 {
+		line = tool.line
+	}
+	if w.Default < 0.0 || w.Default > 1.0 {
+		v.addError(line, "tool %q: default weight %v is not between 0 and 1", tool.Id, w.Default)
+	}
+	for i := range w.ConditionalWeights {
+		cw := &w.ConditionalWeights[i]
+		if cw.Value < 0.0 || cw.Value > 1.0 {
+			v.addError(
+				cw.line,
+				"tool %q: conditional weight %v is not between 0 and 1",
+				tool.Id,
+				cw.Value,
+			)
+		}
+		if len(cw.Conditions) == 0 {
+			v.addError(
+				cw.line,
+				"tool %q: conditional weight without conditions is always applied, use the default weight instead",
+				tool.Id,
+			)
+		}
+		for j := range cw.Conditions {
+			v.validateCondition(
+				&cw.Conditions[j],
+				fmt.Sprintf("tool %q: conditional weight condition", tool.Id),
+				false,
+			)
+		}
+	}
+}
+
+// getValueType returns the feature type of a value from the configuration.
+func getValueType(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64, float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// The following functions record the line of configuration entries, so that
+// validation errors can name them.
+
+func (c *ToolConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain ToolConfig
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (t *Trigger) UnmarshalYAML(node *yaml.Node) error {
+	type plain Trigger
+	t.line = node.Line
+	return node.Decode((*plain)(t))
+}
+
+func (r *FileIdentityRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain FileIdentityRule
+	r.line = node.Line
+	return node.Decode((*plain)(r))
+}
+
+func (c *FeatureConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain FeatureConfig
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (c *MergeCondition) UnmarshalYAML(node *yaml.Node) error {
+	type plain MergeCondition
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (w *Weight) UnmarshalYAML(node *yaml.Node) error {
+	type plain Weight
+	w.line = node.Line
+	return node.Decode((*plain)(w))
+}
+
+func (w *ConditionalWeight) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConditionalWeight
+	w.line = node.Line
+	return node.Decode((*plain)(w))
+}
+
+func (c *FeatureCondition) UnmarshalYAML(node *yaml.Node) error {
+	type plain FeatureCondition
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (p *AnalysisProfile) UnmarshalYAML(node *yaml.Node) error {
+	type plain AnalysisProfile
+	p.line = node.Line
+	return node.Decode((*plain)(p))
+}
+
+func (c *TriggerChainingConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain TriggerChainingConfig
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (c *CircuitBreakerConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain CircuitBreakerConfig
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
+
+func (l *ArchiveLimits) UnmarshalYAML(node *yaml.Node) error {
+	type plain ArchiveLimits
+	l.line = node.Line
+	return node.Decode((*plain)(l))
+}
+
+func (c *CacheConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain CacheConfig
+	c.line = node.Line
+	return node.Decode((*plain)(c))
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TEST_TOOLS declares two tools, which the configurations of the tests extend.
+const TEST_TOOLS = `
+tools:
+  - id: "siegfried"
+    enabled: true
+    endpoint: "http://siegfried/identify"
+    featureSet:
+      features:
+        - key: "format:puid"
+        - key: "format:mimeType"
+      weight:
+        default: 0.75
+  - id: "droid"
+    endpoint: "http://droid/identify"
+`
+
+func TestValidateShippedConfig(t *testing.T) {
+	data, err := os.ReadFile("../../config/server_config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseConfigData(data, CONFIG_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// wantErrors are parts of the expected error messages, including the
+		// line of the mistake.
+		wantErrors []string
+	}{
+		{
+			name: "valid",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    endpoint: "http://jhove/validate"
+    triggers:
+      - conditions:
+          - feature: "format:puid"
+            regEx: "^fmt/(19|20)$"
+    featureSet:
+      features:
+        - key: "format:mimeType"
+          mergeCondition:
+            valueRegEx: "^[^/]+/(.+)$"
+`,
+		},
+		{
+			name: "duplicate tool id",
+			data: TEST_TOOLS + `
+  - id: "droid"
+`,
+			wantErrors: []string{`test.yml:15: duplicate tool id "droid", first defined in line 12`},
+		},
+		{
+			name: "invalid endpoint and unknown fallback",
+			data: TEST_TOOLS + `
+  - id: "tika"
+    enabled: true
+    endpoint: "tika/extract-metadata"
+    fallback: "magika"
+`,
+			wantErrors: []string{
+				`tool "tika": invalid endpoint "tika/extract-metadata"`,
+				`tool "tika": unknown fallback tool "magika"`,
+			},
+		},
+		{
+			name: "invalid regEx",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    triggers:
+      - conditions:
+          - feature: "format:puid"
+            regEx: "fmt/(19"
+`,
+			wantErrors: []string{`test.yml:18: tool "jhove": trigger condition has an invalid regEx`},
+		},
+		{
+			name: "valueRegEx without capture group",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    featureSet:
+      features:
+        - key: "format:mimeType"
+          mergeCondition:
+            valueRegEx: "^[^/]+/.+$"
+`,
+			wantErrors: []string{`tool "jhove": valueRegEx for feature "format:mimeType" must contain exactly one capture group`},
+		},
+		{
+			name: "unknown feature and type mismatch",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    triggers:
+      - conditions:
+          - feature: "format:unknown"
+            exists: true
+          - feature: "format:puid"
+            gt: 1
+`,
+			wantErrors: []string{
+				`references unknown feature "format:unknown"`,
+				`compares string feature "format:puid" with a number`,
+			},
+		},
+		{
+			name: "conflicting tests",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    triggers:
+      - conditions:
+          - feature: "format:puid"
+            exists: true
+            absent: true
+          - feature: "file:size"
+            between: [10, 1]
+`,
+			wantErrors: []string{
+				"has both exists and absent",
+				"between has a lower bound greater than the upper bound",
+			},
+		},
+		{
+			name: "weight out of range",
+			data: TEST_TOOLS + `
+  - id: "jhove"
+    featureSet:
+      weight:
+        default: 1.5
+`,
+			wantErrors: []string{`tool "jhove": default weight 1.5 is not between 0 and 1`},
+		},
+		{
+			name: "unknown default profile",
+			data: TEST_TOOLS + `
+defaultProfile: "fast"
+`,
+			wantErrors: []string{`unknown default profile "fast"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConfigData([]byte(test.data), "test.yml")
+			if len(test.wantErrors) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", test.wantErrors)
+			}
+			var configError ConfigError
+			if !errors.As(err, &configError) {
+				t.Errorf("got error %T, want a ConfigError", err)
+			}
+			for _, want := range test.wantErrors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// TestUnvalidatedRegEx checks that regular expressions work in configurations
+// that weren't validated, like hand-built ones.
+func TestUnvalidatedRegEx(t *testing.T) {
+	pattern := "^fmt/(19|20)$"
+	condition := FeatureCondition{Feature: "format:puid", RegEx: &pattern}
+	result := newTestToolResult("siegfried", map[string]interface{}{"format:puid": "fmt/19"})
+	if !condition.IsFulfilled(lookupToolResult(result), nil) {
+		t.Error("got regEx condition not fulfilled, want fulfilled")
+	}
+	valuePattern := "^[^/]+/(.+)$"
+	mergeCondition := MergeCondition{ValueRegEx: &valuePattern}
+	isFulfilled, strongLink := mergeCondition.IsFulfilled(
+		"format:mimeType",
+		map[string]MergeFeatureValue{"format:mimeType": {Value: "application/pdf"}},
+		map[string]ToolFeatureValue{"format:mimeType": {Value: "image/pdf"}},
+	)
+	if !isFulfilled || !strongLink {
+		t.Errorf("got fulfilled %t and strong link %t, want both", isFulfilled, strongLink)
+	}
+	invalid := "fmt/(19"
+	condition.RegEx = &invalid
+	if condition.IsFulfilled(lookupToolResult(result), nil) {
+		t.Error("got invalid regEx condition fulfilled, want not fulfilled")
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -12,9 +14,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -22,7 +24,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -44,13 +46,15 @@ const (
 	STORE_DIR                     = "/borg/file-store"
 	SIGNATURE_FILE_NAME           = "DROID_SignatureFile_V120.xml"
 	CONTAINER_SIGNATURE_FILE_NAME = "container-signature-20240715.xml"
-	TIMEOUT                       = 60 * time.Second
 )
 
 func main() {
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/identify", identifyFileFormat)
 	router.Run()
 }
@@ -59,6 +63,15 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{
+		"toolVersion":      TOOL_VERSION,
+		"signatureVersion": SIGNATURE_FILE_NAME + "; " + CONTAINER_SIGNATURE_FILE_NAME,
+	})
+}
+
 var (
 	signatureFilePath          = filepath.Join(WORK_DIR, "third_party", SIGNATURE_FILE_NAME)
 	containerSignatureFilePath = filepath.Join(WORK_DIR, "third_party", CONTAINER_SIGNATURE_FILE_NAME)
@@ -70,15 +83,18 @@ func identifyFileFormat(ginContext *gin.Context) {
 	_, err := os.Stat(fileStorePath)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error processing file: %s", fileStorePath)
 		response := ToolResponse{
 			ToolVersion: TOOL_VERSION,
-			Error:       &errorMessage,
+			Error: toolapi.NewToolError(
+				toolapi.ERROR_CODE_FILE_NOT_FOUND,
+				fmt.Sprintf("error processing file: %s", fileStorePath),
+			),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -90,23 +106,23 @@ func identifyFileFormat(ginContext *gin.Context) {
 		containerSignatureFilePath,
 		fileStorePath,
 	)
-	droidOutput, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	droidOutput, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: TOOL_VERSION,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error executing DROID command: %s", string(droidOutput))
 		response := ToolResponse{
 			ToolVersion: TOOL_VERSION,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing DROID command", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -121,12 +137,11 @@ func identifyFileFormat(ginContext *gin.Context) {
 		if err != nil {
 			log.Println(err.Error())
 		}
-		errorMessage := "unable to parse DROID csv output"
 		response := ToolResponse{
 			ToolVersion:  TOOL_VERSION,
 			ToolOutput:   droidOutputString,
 			OutputFormat: "csv",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, "unable to parse DROID csv output"),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -134,12 +149,11 @@ func identifyFileFormat(ginContext *gin.Context) {
 	features, err := extractFeatures(formatTable)
 	if err != nil {
 		log.Println(err.Error())
-		errorMessage := "unable to parse DROID csv output"
 		response := ToolResponse{
 			ToolVersion:  TOOL_VERSION,
 			ToolOutput:   droidOutputString,
 			OutputFormat: "csv",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, "unable to parse DROID csv output"),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
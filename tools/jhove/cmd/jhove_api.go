@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -12,9 +13,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -22,7 +23,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -57,28 +58,57 @@ var (
 const (
 	defaultResponse = "JHOVE API is running"
 	storeDir        = "/borg/file-store"
-	TIMEOUT         = 60 * time.Second
 )
 
+var toolVersion string
+
 func main() {
+	toolVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("validate/:module", validateFile)
 	router.Run()
 }
 
+// getToolVersion returns the release of JHOVE. The version is also part of
+// every validation result, so a failure isn't fatal.
+func getToolVersion() string {
+	cmd := exec.Command("./jhove/jhove", "-v")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	regEx := regexp.MustCompile(`Rel\. ([0-9.]+)`)
+	matches := regEx.FindStringSubmatch(string(output))
+	if len(matches) != 2 {
+		log.Println("couldn't extract JHOVE version from tool output")
+		return ""
+	}
+	return matches[1]
+}
+
 func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, defaultResponse)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 func validateFile(ginContext *gin.Context) {
 	module := ginContext.Param("module")
 	if module == "" {
 		errorMessage := "no JHOVE module declared"
 		log.Println(errorMessage)
 		response := ToolResponse{
-			Error: &errorMessage,
+			Error: toolapi.NewToolError(toolapi.ERROR_CODE_UNSUPPORTED, errorMessage),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -90,12 +120,13 @@ func validateFile(ginContext *gin.Context) {
 		log.Println(errorMessage)
 		log.Println(err)
 		response := ToolResponse{
-			Error: &errorMessage,
+			Error: toolapi.NewToolError(toolapi.ERROR_CODE_FILE_NOT_FOUND, errorMessage),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -106,15 +137,14 @@ func validateFile(ginContext *gin.Context) {
 		"json",
 		fileStorePath,
 	)
+	toolapi.KillProcessGroup(cmd)
 	var outBuffer, errBuffer bytes.Buffer
 	cmd.Stdout = &outBuffer
 	cmd.Stderr = &errBuffer
 	err = cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
-			Error: &errorMessage,
+			Error: toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -124,7 +154,7 @@ func validateFile(ginContext *gin.Context) {
 		log.Println(errorMessage)
 		log.Println(err)
 		response := ToolResponse{
-			Error: &errorMessage,
+			Error: toolapi.NewProcessError("error executing JHOVE command", err, errBuffer.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -147,7 +177,7 @@ func processJhoveOutput(context *gin.Context, output string, module string) {
 		response := ToolResponse{
 			ToolOutput:   output,
 			OutputFormat: "text",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, errorMessage),
 		}
 		context.JSON(http.StatusOK, response)
 		return
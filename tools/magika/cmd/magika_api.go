@@ -1,18 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -21,7 +23,7 @@ type ToolResponse struct {
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
 	Score        *float64                    `json:"score"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -62,7 +64,6 @@ const (
 	defaultResponse = "Magika API is running"
 	workDir         = "/borg/tools/magika"
 	storeDir        = "/borg/file-store"
-	TIMEOUT         = 60 * time.Second
 )
 
 var (
@@ -76,7 +77,10 @@ func main() {
 	toolVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/identify", identifyFileFormat)
 	router.Run()
 }
@@ -85,6 +89,12 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, defaultResponse)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 func getToolVersion() string {
 	cmd := exec.Command(
 		"magika",
@@ -109,15 +119,18 @@ func identifyFileFormat(ginContext *gin.Context) {
 	_, err := os.Stat(fileStorePath)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error processing file: %s", fileStorePath)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error: toolapi.NewToolError(
+				toolapi.ERROR_CODE_FILE_NOT_FOUND,
+				fmt.Sprintf("error processing file: %s", fileStorePath),
+			),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -125,13 +138,14 @@ func identifyFileFormat(ginContext *gin.Context) {
 		"--json",
 		fileStorePath,
 	)
-	magikaOutput, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	magikaOutput, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -140,11 +154,10 @@ func identifyFileFormat(ginContext *gin.Context) {
 	if err != nil {
 		log.Println(magikaOutputString)
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error executing Magika command: %s", magikaOutputString)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
 			ToolOutput:  magikaOutputString,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing Magika command", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -153,12 +166,21 @@ func identifyFileFormat(ginContext *gin.Context) {
 	err = json.Unmarshal(magikaOutput, &data)
 	if err != nil {
 		log.Println(err)
-		errorMessage := "unable to parse Magika JSON output"
 		response := ToolResponse{
 			ToolVersion:  toolVersion,
 			ToolOutput:   magikaOutputString,
 			OutputFormat: "json",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, "unable to parse Magika JSON output"),
+		}
+		ginContext.JSON(http.StatusOK, response)
+		return
+	}
+	if len(data) == 0 {
+		response := ToolResponse{
+			ToolVersion:  toolVersion,
+			ToolOutput:   magikaOutputString,
+			OutputFormat: "json",
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_NO_RESULT, "no identification results"),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -13,9 +15,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -23,7 +25,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -35,7 +37,6 @@ const (
 	defaultResponse = "MediaInfo API is running"
 	workDir         = "/borg/tools/magika"
 	storeDir        = "/borg/file-store"
-	TIMEOUT         = 60 * time.Second
 )
 
 var (
@@ -48,7 +49,10 @@ func main() {
 	dict = readLocalizationCsv()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/localization-dict", getLocalizationDict)
 	router.GET("/extract-metadata", extractMetadata)
 	router.Run()
@@ -76,6 +80,12 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, defaultResponse)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 func getLocalizationDict(context *gin.Context) {
 	context.JSON(http.StatusOK, dict)
 }
@@ -85,15 +95,18 @@ func extractMetadata(ginContext *gin.Context) {
 	_, err := os.Stat(fileStorePath)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error processing file: %s", fileStorePath)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error: toolapi.NewToolError(
+				toolapi.ERROR_CODE_FILE_NOT_FOUND,
+				fmt.Sprintf("error processing file: %s", fileStorePath),
+			),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -102,13 +115,14 @@ func extractMetadata(ginContext *gin.Context) {
 		"--Output=XML",
 		fileStorePath,
 	)
-	output, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -116,21 +130,18 @@ func extractMetadata(ginContext *gin.Context) {
 	outputString := string(output)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf(
-			"error executing MediaInfo command: %s", outputString)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing MediaInfo command", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
 	features, err := extractFeatures(outputString)
 	if err != nil {
-		errorMessage := err.Error()
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, err.Error()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"errors"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -13,12 +14,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	STORE_DIR        = "/borg/file-store"
 	DEFAULT_RESPONSE = "ODF Validator API is running"
-	TIMEOUT          = 60 * time.Second
 )
 
 type ToolResponse struct {
@@ -26,7 +27,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -45,7 +46,10 @@ func main() {
 	toolVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("validate", validate)
 	router.Run()
 }
@@ -74,15 +78,20 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 // validate is the API endpoint for validating a file with ODF Validator.
 func validate(context *gin.Context) {
 	path := filepath.Join(STORE_DIR, context.Query("path"))
-	valid, output, err := validateFile(path)
-	if err != nil {
-		errorMessage := err.Error()
+	valid, output, toolError := validateFile(context.Request.Context(), path, toolapi.GetTimeout(context))
+	if toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		context.JSON(http.StatusOK, response)
 		return
@@ -113,18 +122,22 @@ func validate(context *gin.Context) {
 //
 // It returns
 // - a boolean indicating whether the file is valid ODF
-// - the command's combined stdout and stderr output
+// - the command's standard output
 // - an error if validation failed for unforeseen reasons.
-func validateFile(path string) (bool, string, error) {
+func validateFile(
+	requestContext context.Context,
+	path string,
+	timeout time.Duration,
+) (bool, string, *toolapi.ToolError) {
 	_, err := os.Stat(path)
 	if err != nil {
 		errorMessage := "error processing file: " + path
 		log.Println(errorMessage)
 		log.Println(err)
-		return false, "", errors.New(errorMessage)
+		return false, "", toolapi.NewToolError(toolapi.ERROR_CODE_FILE_NOT_FOUND, errorMessage)
 	}
 	// -v for verbose output to extract the MIME type
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	ctx, cancel := context.WithTimeout(requestContext, timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -136,11 +149,12 @@ func validateFile(path string) (bool, string, error) {
 		"-e",
 		path,
 	)
-	output, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
-		return false, "", errors.New(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
+		return false, "", toolError
 	}
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -151,7 +165,7 @@ func validateFile(path string) (bool, string, error) {
 		}
 		errorMessage := fmt.Sprintf("error executing ODF-Validator command: %v", err)
 		log.Println(errorMessage)
-		return false, "", errors.New(errorMessage)
+		return false, "", toolapi.NewProcessError(errorMessage, err, stderr.String())
 	}
 	return true, string(output), nil
 }
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"errors"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -19,7 +21,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -35,13 +37,15 @@ const (
 	TOOL_VERSION     = "2.1.5"
 	STORE_DIR        = "/borg/file-store"
 	DEFAULT_RESPONSE = "OOXML-Validator API is running"
-	TIMEOUT          = 60 * time.Second
 )
 
 func main() {
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("validate", validate)
 	router.Run()
 }
@@ -51,15 +55,20 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": TOOL_VERSION})
+}
+
 // validate is the API endpoint for validating a file with OOXML-Validator.
 func validate(context *gin.Context) {
 	path := filepath.Join(STORE_DIR, context.Query("path"))
-	valid, output, err := validateFile(path)
-	if err != nil {
-		errorMessage := err.Error()
+	valid, output, toolError := validateFile(context.Request.Context(), path, toolapi.GetTimeout(context))
+	if toolError != nil {
 		response := ToolResponse{
 			ToolVersion: TOOL_VERSION,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		context.JSON(http.StatusOK, response)
 		return
@@ -82,30 +91,34 @@ func validate(context *gin.Context) {
 //
 // It returns
 // - a boolean indicating whether the file is valid OOXML
-// - the command's combined stdout and stderr output
+// - the command's standard output
 // - an error if validation failed for unforeseen reasons.
-func validateFile(path string) (valid bool, output string, err error) {
-	_, err = os.Stat(path)
+func validateFile(
+	requestContext context.Context,
+	path string,
+	timeout time.Duration,
+) (bool, string, *toolapi.ToolError) {
+	_, err := os.Stat(path)
 	if err != nil {
 		err = fmt.Errorf("error processing file %s: %w", path, err)
 		log.Println(err)
-		return false, "", err
+		return false, "", toolapi.NewToolError(toolapi.ERROR_CODE_FILE_NOT_FOUND, err.Error())
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	ctx, cancel := context.WithTimeout(requestContext, timeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, "third_party/OOXMLValidatorCLI", path)
-	outputBytes, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
-		return false, "", errors.New(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	outputBytes, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
+		return false, "", toolError
 	}
+	output := string(outputBytes)
 	if err != nil {
-		err = fmt.Errorf("error executing OOXML-Validator command: %w", err)
-		log.Println(err)
-		return false, "", err
+		errorMessage := fmt.Sprintf("error executing OOXML-Validator command: %v", err)
+		log.Println(errorMessage)
+		return false, "", toolapi.NewProcessError(errorMessage, err, stderr.String())
 	}
-	output = string(outputBytes)
-	valid = output == "[]"
-	return valid, output, nil
+	return output == "[]", output, nil
 }
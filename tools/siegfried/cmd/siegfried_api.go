@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -11,9 +13,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -21,7 +23,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -57,21 +59,26 @@ const (
 	DEFAULT_RESPONSE = "Siegfried API is running"
 	WORK_DIR         = "/borg/tools/siegfried"
 	STORE_DIR        = "/borg/file-store"
-	TIMEOUT          = 60 * time.Second
 )
 
 var toolVersion string
 
+// signatureVersion names the PRONOM signature files of Siegfried.
+var signatureVersion string
+
 func main() {
-	toolVersion = getToolVersion()
+	toolVersion, signatureVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/identify", identifyFileFormat)
 	router.Run()
 }
 
-func getToolVersion() string {
+func getToolVersion() (string, string) {
 	cmd := exec.Command(
 		"./third_party/sf",
 		"-v",
@@ -86,27 +93,45 @@ func getToolVersion() string {
 	if len(matches) != 2 {
 		log.Fatal("couldn't extract Siegfried version from tool output")
 	}
-	return matches[1]
+	signatureRegEx := regexp.MustCompile(`pronom:\s*(.+)`)
+	signatureMatches := signatureRegEx.FindStringSubmatch(outputString)
+	if len(signatureMatches) != 2 {
+		log.Println("couldn't extract Siegfried signature version from tool output")
+		return matches[1], ""
+	}
+	return matches[1], strings.TrimSpace(signatureMatches[1])
 }
 
 func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{
+		"toolVersion":      toolVersion,
+		"signatureVersion": signatureVersion,
+	})
+}
+
 func identifyFileFormat(ginContext *gin.Context) {
 	fileStorePath := filepath.Join(STORE_DIR, ginContext.Query("path"))
 	_, err := os.Stat(fileStorePath)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf("error processing file: %s", fileStorePath)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error: toolapi.NewToolError(
+				toolapi.ERROR_CODE_FILE_NOT_FOUND,
+				fmt.Sprintf("error processing file: %s", fileStorePath),
+			),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -114,13 +139,14 @@ func identifyFileFormat(ginContext *gin.Context) {
 		"-json",
 		fileStorePath,
 	)
-	output, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	toolapi.KillProcessGroup(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -128,11 +154,9 @@ func identifyFileFormat(ginContext *gin.Context) {
 	outputString := string(output)
 	if err != nil {
 		log.Println(err)
-		errorMessage := fmt.Sprintf(
-			"error executing Siegfried command: %s", outputString)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing Siegfried command", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -141,10 +165,9 @@ func identifyFileFormat(ginContext *gin.Context) {
 	err = json.Unmarshal(output, &result)
 	if err != nil {
 		log.Println(err)
-		errorMessage := err.Error()
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, err.Error()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -161,9 +184,10 @@ func identifyFileFormat(ginContext *gin.Context) {
 					ToolOutput:   outputString,
 					OutputFormat: "json",
 					Features:     features,
-					Error:        &errorMessage,
+					Error:        toolapi.NewToolError(toolapi.ERROR_CODE_NO_RESULT, errorMessage),
 				}
 				ginContext.JSON(http.StatusOK, response)
+				return
 			}
 			if match.Id != "UNKNOWN" {
 				features["format:puid"] = ToolFeatureValue{
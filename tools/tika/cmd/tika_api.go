@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -12,9 +13,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -22,7 +23,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -47,7 +48,6 @@ const (
 	DEFAULT_RESPONSE = "Tika API is running"
 	WORK_DIR         = "/borg/tools/tika"
 	STORE_DIR        = "/borg/file-store"
-	TIMEOUT          = 60 * time.Second
 )
 
 var toolVersion string
@@ -56,7 +56,10 @@ func main() {
 	toolVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/extract-metadata", extractMetadata)
 	router.Run()
 }
@@ -65,6 +68,12 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 func getToolVersion() string {
 	cmd := exec.Command(
 		"java",
@@ -92,12 +101,13 @@ func extractMetadata(ginContext *gin.Context) {
 		log.Println(errorMessage)
 		log.Println(err)
 		response := ToolResponse{
-			Error: &errorMessage,
+			Error: toolapi.NewToolError(toolapi.ERROR_CODE_FILE_NOT_FOUND, errorMessage),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -108,15 +118,14 @@ func extractMetadata(ginContext *gin.Context) {
 		"--json",
 		fileStorePath,
 	)
+	toolapi.KillProcessGroup(cmd)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	tikaOutput, err := cmd.Output()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -127,7 +136,7 @@ func extractMetadata(ginContext *gin.Context) {
 		log.Println(err)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing Tika command", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -147,7 +156,7 @@ func processTikaOutput(context *gin.Context, output string) {
 			ToolVersion:  toolVersion,
 			ToolOutput:   output,
 			OutputFormat: "text",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, errorMessage),
 		}
 		context.JSON(http.StatusOK, response)
 		return
@@ -0,0 +1,69 @@
+// Package toolapi contains the types and helpers that the tool wrappers and
+// the Borg server share to describe the results of the tools, as well as the
+// metrics of the tool wrappers.
+package toolapi
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+)
+
+// Error codes of ToolError. ERROR_CODE_UNAVAILABLE is only set by the server,
+// the remaining codes are reported by the tool wrappers as well.
+const (
+	ERROR_CODE_TIMEOUT           = "timeout"
+	ERROR_CODE_FILE_NOT_FOUND    = "file_not_found"
+	ERROR_CODE_TOOL_FAILED       = "tool_failed"
+	ERROR_CODE_OUTPUT_UNPARSABLE = "output_unparsable"
+	ERROR_CODE_NO_RESULT         = "no_result"
+	ERROR_CODE_UNSUPPORTED       = "unsupported"
+	// ERROR_CODE_UNAVAILABLE means that the tool wrapper couldn't be reached,
+	// responded with an error status or was skipped by the circuit breaker.
+	ERROR_CODE_UNAVAILABLE = "unavailable"
+	// ERROR_CODE_CANCELED means that the analysis was canceled. A tool wrapper
+	// reports it if the request was canceled while the tool was running.
+	ERROR_CODE_CANCELED = "canceled"
+)
+
+// ToolError describes why a tool failed. ExitCode and Stderr are only set if
+// the tool process failed.
+type ToolError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	ExitCode *int   `json:"exitCode"`
+	// Stderr is the error output of the tool process.
+	Stderr *string `json:"stderr"`
+}
+
+// NewToolError creates an error that isn't caused by a failed tool process.
+func NewToolError(code string, message string) *ToolError {
+	return &ToolError{Code: code, Message: message}
+}
+
+// NewProcessError creates an error for a tool process that failed with err.
+// stderr is the captured error output of the process.
+func NewProcessError(message string, err error, stderr string) *ToolError {
+	toolError := NewToolError(ERROR_CODE_TOOL_FAILED, message)
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		exitCode := exitError.ExitCode()
+		toolError.ExitCode = &exitCode
+	}
+	if stderr != "" {
+		toolError.Stderr = &stderr
+	}
+	return toolError
+}
+
+// UnmarshalJSON accepts the plain error messages of older tool wrappers and
+// persisted job results as well.
+func (e *ToolError) UnmarshalJSON(data []byte) error {
+	var message string
+	if json.Unmarshal(data, &message) == nil {
+		*e = ToolError{Code: ERROR_CODE_TOOL_FAILED, Message: message}
+		return nil
+	}
+	type plain ToolError
+	return json.Unmarshal(data, (*plain)(e))
+}
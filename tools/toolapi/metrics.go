@@ -0,0 +1,57 @@
+package toolapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons for killing a tool process.
+const (
+	KILL_REASON_TIMEOUT  = "timeout"
+	KILL_REASON_CANCELED = "canceled"
+)
+
+// DURATION_BUCKETS are the buckets of the request duration histogram in
+// seconds. They cover fast identifications as well as long validations.
+var DURATION_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_wrapper_requests_total",
+		Help: "Number of requests by handler and HTTP status code.",
+	}, []string{"handler", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borg_wrapper_request_duration_seconds",
+		Help:    "Duration of the requests by handler.",
+		Buckets: DURATION_BUCKETS,
+	}, []string{"handler"})
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "borg_wrapper_requests_in_flight",
+		Help: "Number of requests currently processed.",
+	})
+	killedProcesses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_wrapper_killed_processes_total",
+		Help: "Number of tool processes killed because of a timeout or a canceled request.",
+	}, []string{"reason"})
+)
+
+// RecordRequestMetrics is a middleware recording the number, the duration and
+// the status of the requests for the metrics endpoint.
+func RecordRequestMetrics(ginContext *gin.Context) {
+	requestsInFlight.Inc()
+	defer requestsInFlight.Dec()
+	start := time.Now()
+	ginContext.Next()
+	handler := ginContext.FullPath()
+	requestDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+	requestsTotal.WithLabelValues(handler, strconv.Itoa(ginContext.Writer.Status())).Inc()
+}
+
+// RecordKilledProcess counts a tool process killed for the given reason.
+func RecordKilledProcess(reason string) {
+	killedProcesses.WithLabelValues(reason).Inc()
+}
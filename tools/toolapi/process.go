@@ -0,0 +1,54 @@
+package toolapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DEFAULT_TIMEOUT is the timeout of a tool process if the Borg server doesn't
+// request one.
+const DEFAULT_TIMEOUT = 60 * time.Second
+
+// GetTimeout returns the timeout requested by the Borg server with the query
+// parameter timeout, a duration like "90s". DEFAULT_TIMEOUT is used if the
+// parameter is missing or invalid.
+func GetTimeout(ginContext *gin.Context) time.Duration {
+	timeout, err := time.ParseDuration(ginContext.Query("timeout"))
+	if err != nil || timeout <= 0 {
+		return DEFAULT_TIMEOUT
+	}
+	return timeout
+}
+
+// KillProcessGroup makes the command kill its whole process group when the
+// context is done, so that subprocesses of the tool don't keep running.
+func KillProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// GetProcessContextError returns an error if the tool process run with ctx was
+// killed, because the request was canceled or the timeout was exceeded. The
+// killed process is recorded for the metrics.
+func GetProcessContextError(ctx context.Context, timeout time.Duration) *ToolError {
+	switch ctx.Err() {
+	case context.Canceled:
+		RecordKilledProcess(KILL_REASON_CANCELED)
+		log.Println("request canceled, tool process killed")
+		return NewToolError(ERROR_CODE_CANCELED, "request canceled")
+	case context.DeadlineExceeded:
+		RecordKilledProcess(KILL_REASON_TIMEOUT)
+		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", timeout)
+		log.Println(errorMessage)
+		return NewToolError(ERROR_CODE_TIMEOUT, errorMessage)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"lath/borg/toolapi"
 	"log"
 	"net/http"
 	"os"
@@ -12,9 +13,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ToolResponse struct {
@@ -22,7 +23,7 @@ type ToolResponse struct {
 	ToolOutput   string                      `json:"toolOutput"`
 	OutputFormat string                      `json:"outputFormat"`
 	Features     map[string]ToolFeatureValue `json:"features"`
-	Error        *string                     `json:"error"`
+	Error        *toolapi.ToolError          `json:"error"`
 }
 
 type ToolFeatureValue struct {
@@ -58,7 +59,6 @@ const (
 	DEFAULT_RESPONSE = "veraPDF API is running"
 	WORK_DIR         = "/borg/tools/verapdf"
 	STORE_DIR        = "/borg/file-store"
-	TIMEOUT          = 60 * time.Second
 )
 
 var toolVersion string
@@ -67,7 +67,10 @@ func main() {
 	toolVersion = getToolVersion()
 	router := gin.Default()
 	router.SetTrustedProxies(nil)
+	router.Use(toolapi.RecordRequestMetrics)
 	router.GET("", getDefaultResponse)
+	router.GET("/version", getVersion)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/validate/:profile", validateFile)
 	router.Run()
 }
@@ -76,6 +79,12 @@ func getDefaultResponse(context *gin.Context) {
 	context.String(http.StatusOK, DEFAULT_RESPONSE)
 }
 
+// getVersion returns the version of the tool for the health check and the tool
+// inventory of the Borg server.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"toolVersion": toolVersion})
+}
+
 func getToolVersion() string {
 	cmd := exec.Command(
 		"/bin/ash",
@@ -101,7 +110,7 @@ func validateFile(ginContext *gin.Context) {
 		log.Println(errorMessage)
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewToolError(toolapi.ERROR_CODE_UNSUPPORTED, errorMessage),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -114,12 +123,13 @@ func validateFile(ginContext *gin.Context) {
 		log.Println(err.Error())
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewToolError(toolapi.ERROR_CODE_FILE_NOT_FOUND, errorMessage),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	timeout := toolapi.GetTimeout(ginContext)
+	ctx, cancel := context.WithTimeout(ginContext.Request.Context(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(
 		ctx,
@@ -129,15 +139,14 @@ func validateFile(ginContext *gin.Context) {
 		"--format", "json",
 		"-v", fileStorePath,
 	)
+	toolapi.KillProcessGroup(cmd)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	veraPDFOutput, err := cmd.Output()
-	if ctx.Err() == context.DeadlineExceeded {
-		errorMessage := fmt.Sprintf("Timeout exceeded after %s.", TIMEOUT)
-		log.Println(errorMessage)
+	if toolError := toolapi.GetProcessContextError(ctx, timeout); toolError != nil {
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolError,
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -146,10 +155,9 @@ func validateFile(ginContext *gin.Context) {
 	if err != nil && err.Error() != "exit status 1" {
 		log.Println(err.Error())
 		log.Println(stderr.String())
-		errorMessage := fmt.Sprintf("error executing verPDF: %s", stderr.String())
 		response := ToolResponse{
 			ToolVersion: toolVersion,
-			Error:       &errorMessage,
+			Error:       toolapi.NewProcessError("error executing veraPDF", err, stderr.String()),
 		}
 		ginContext.JSON(http.StatusOK, response)
 		return
@@ -169,7 +177,7 @@ func processVeraPDFOutput(context *gin.Context, output string, profile string) {
 			ToolVersion:  toolVersion,
 			ToolOutput:   output,
 			OutputFormat: "text",
-			Error:        &errorMessage,
+			Error:        toolapi.NewToolError(toolapi.ERROR_CODE_OUTPUT_UNPARSABLE, errorMessage),
 		}
 		context.JSON(http.StatusOK, response)
 		return